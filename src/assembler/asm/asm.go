@@ -0,0 +1,517 @@
+// Package asm assembles the mnemonics printed by the disassembler into
+// CHIP-8 ROMs.
+package asm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/abhinand20/emugo/common"
+)
+
+const maxIncludeDepth = 16
+
+// Reserved operand names that can never be labels or constants
+var fixedOperands = map[string]bool{
+	"I": true, "DT": true, "ST": true, "K": true, "F": true,
+	"HF": true, "B": true, "[I]": true, "R": true,
+}
+
+var (
+	// Address and opcode columns of a disassembler listing line
+	listingAddrRe = regexp.MustCompile(`^([0-9A-Fa-f]{4}):\s*`)
+	listingOpcodeRe = regexp.MustCompile(`^[0-9A-Fa-f]{4}\s+`)
+	labelRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.]*):\s*`)
+	constantRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.]*)\s*(?:=|\s[Ee][Qq][Uu]\s)\s*(.+)$`)
+	registerRe = regexp.MustCompile(`^[Vv]([0-9A-Fa-f])$`)
+)
+
+// asmStatement is an instruction or data directive placed at addr.
+type asmStatement struct {
+	pos string
+	addr uint16
+	mnemonic string
+	operands []string
+	// Matched definition, nil for data directives
+	def *common.OpDef
+	size int
+}
+
+type constant struct {
+	pos string
+	expr string
+}
+
+type assembler struct {
+	statements []asmStatement
+	labels map[string]uint16
+	constants map[string]constant
+	// Constants being evaluated, to detect cycles
+	resolving map[string]bool
+	addr int
+	end int
+}
+
+// AssembleFile assembles the source file at path, resolving includes
+// relative to the including file.
+func AssembleFile(path string) (*common.AssembledProgram, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read source '%s': %v", path, err)
+	}
+	return assemble(path, string(source))
+}
+
+// AssembleSource assembles source held in memory, name is used in errors
+// and as the base for relative includes.
+func AssembleSource(name, source string) (*common.AssembledProgram, error) {
+	return assemble(name, source)
+}
+
+func assemble(name, source string) (*common.AssembledProgram, error) {
+	a := &assembler{
+		labels: make(map[string]uint16),
+		constants: make(map[string]constant),
+		resolving: make(map[string]bool),
+		addr: common.StartAddr,
+		end: common.StartAddr,
+	}
+	if err := a.parse(name, source, 0); err != nil {
+		return nil, err
+	}
+	if err := a.checkOverlaps(); err != nil {
+		return nil, err
+	}
+	program := &common.AssembledProgram{
+		Bytes: make([]byte, a.end - common.StartAddr),
+		Symbols: make(map[string]uint16),
+	}
+	for _, st := range a.statements {
+		encoded, err := a.encode(st)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", st.pos, err)
+		}
+		copy(program.Bytes[int(st.addr) - common.StartAddr:], encoded)
+	}
+	for label, addr := range a.labels {
+		program.Symbols[label] = addr
+	}
+	for name, c := range a.constants {
+		value, err := a.resolve(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c.pos, err)
+		}
+		program.Symbols[name] = uint16(value)
+	}
+	return program, nil
+}
+
+// parse runs the first pass: it lays out statements and records labels
+// and constants, leaving operands to be evaluated once all are known.
+func (a *assembler) parse(name, source string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: includes nested too deeply", name)
+	}
+	for idx, line := range strings.Split(source, "\n") {
+		pos := fmt.Sprintf("%s:%d", name, idx + 1)
+		if err := a.parseLine(name, pos, line, depth); err != nil {
+			return fmt.Errorf("%s: %v", pos, err)
+		}
+	}
+	return nil
+}
+
+func (a *assembler) parseLine(name, pos, line string, depth int) error {
+	line = strings.TrimSpace(stripComment(line))
+	if m := listingAddrRe.FindStringSubmatch(line); m != nil && isListingLine(m[1], line[len(m[0]):]) {
+		addr, _ := strconv.ParseUint(m[1], 16, 16)
+		if int(addr) != a.addr {
+			return fmt.Errorf("listing address %04X does not match assembled address %04X", addr, a.addr)
+		}
+		line = line[len(m[0]):]
+		// Only strip the opcode column when a mnemonic follows it
+		if m := listingOpcodeRe.FindString(line); len(m) > 0 {
+			line = line[len(m):]
+		}
+	}
+	for {
+		m := labelRe.FindStringSubmatch(line)
+		if m == nil {
+			break
+		}
+		if err := a.define(m[1]); err != nil {
+			return err
+		}
+		a.labels[m[1]] = uint16(a.addr)
+		line = line[len(m[0]):]
+	}
+	if len(line) == 0 {
+		return nil
+	}
+	if m := constantRe.FindStringSubmatch(line); m != nil {
+		if err := a.define(m[1]); err != nil {
+			return err
+		}
+		a.constants[m[1]] = constant{pos: pos, expr: strings.TrimSpace(m[2])}
+		return nil
+	}
+	mnemonic, rest, _ := strings.Cut(line, " ")
+	mnemonic = strings.ToUpper(mnemonic)
+	operands := splitOperands(rest)
+	// The shift source register is optional, as in Cowgod's reference
+	if (mnemonic == "SHR" || mnemonic == "SHL") && len(operands) == 1 {
+		operands = append(operands, operands[0])
+	}
+	switch mnemonic {
+	case "INCLUDE":
+		if len(operands) != 1 {
+			return fmt.Errorf("include expects a file name")
+		}
+		path := strings.Trim(operands[0], `"`)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(name), path)
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to include '%s': %v", path, err)
+		}
+		return a.parse(path, string(source), depth + 1)
+	case "ORG":
+		if len(operands) != 1 {
+			return fmt.Errorf("org expects an address")
+		}
+		addr, err := a.eval(operands[0])
+		if err != nil {
+			return err
+		}
+		if addr < common.StartAddr || addr > 0xFFFF {
+			return fmt.Errorf("org address 0x%X outside of program space", addr)
+		}
+		a.addr = addr
+		return nil
+	}
+	st := asmStatement{pos: pos, addr: uint16(a.addr), mnemonic: mnemonic, operands: operands}
+	size := 0
+	switch mnemonic {
+	case "DB":
+		for _, op := range operands {
+			if isString(op) {
+				size += len(op) - 2
+			} else {
+				size++
+			}
+		}
+	case "DW":
+		size = 2 * len(operands)
+	default:
+		st.def = matchOpDef(mnemonic, operands)
+		if st.def == nil {
+			return fmt.Errorf("unknown instruction '%s'", line)
+		}
+		size = st.def.Size
+	}
+	if a.addr + size > 0x10000 {
+		return fmt.Errorf("program does not fit in memory")
+	}
+	st.size = size
+	a.statements = append(a.statements, st)
+	a.addr += size
+	a.end = max(a.end, a.addr)
+	return nil
+}
+
+// isListingLine reports whether addr starts a disassembler listing line
+// rather than being a label. Addresses starting with a letter, such as
+// BEEF, are also valid labels, so those lines need the opcode column.
+func isListingLine(addr, rest string) bool {
+	return addr[0] >= '0' && addr[0] <= '9' || listingOpcodeRe.MatchString(rest)
+}
+
+// checkOverlaps rejects statements assembling to the same bytes, which
+// an org going back can cause.
+func (a *assembler) checkOverlaps() error {
+	sorted := make([]asmStatement, 0, len(a.statements))
+	for _, st := range a.statements {
+		if st.size > 0 {
+			sorted = append(sorted, st)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].addr < sorted[j].addr })
+	for idx := 1; idx < len(sorted); idx++ {
+		prev, st := sorted[idx - 1], sorted[idx]
+		if int(prev.addr) + prev.size > int(st.addr) {
+			return fmt.Errorf("%s: output at 0x%X overlaps the output of %s", st.pos, st.addr, prev.pos)
+		}
+	}
+	return nil
+}
+
+func (a *assembler) define(name string) error {
+	if _, ok := a.labels[name]; ok {
+		return fmt.Errorf("'%s' already defined", name)
+	}
+	if _, ok := a.constants[name]; ok {
+		return fmt.Errorf("'%s' already defined", name)
+	}
+	if fixedOperands[strings.ToUpper(name)] || registerRe.MatchString(name) {
+		return fmt.Errorf("'%s' is a reserved name", name)
+	}
+	return nil
+}
+
+// matchOpDef finds the definition whose syntax accepts the operands.
+func matchOpDef(mnemonic string, operands []string) *common.OpDef {
+	for idx := range common.OpDefs {
+		def := &common.OpDefs[idx]
+		if def.Mnemonic() != mnemonic {
+			continue
+		}
+		placeholders := def.Operands()
+		if len(placeholders) != len(operands) {
+			continue
+		}
+		matched := true
+		for i, placeholder := range placeholders {
+			if !matchOperand(placeholder, operands[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return def
+		}
+	}
+	return nil
+}
+
+func matchOperand(placeholder, operand string) bool {
+	switch placeholder {
+	case "Vx", "Vy":
+		return registerRe.MatchString(operand)
+	case "long":
+		return isLong(operand)
+	case "byte", "addr", "nibble", "plane":
+		return !registerRe.MatchString(operand) && !fixedOperands[strings.ToUpper(operand)] && !isLong(operand)
+	}
+	return strings.EqualFold(placeholder, operand)
+}
+
+// encode runs the second pass over a statement, evaluating its operands.
+func (a *assembler) encode(st asmStatement) ([]byte, error) {
+	switch st.mnemonic {
+	case "DB":
+		var out []byte
+		for _, op := range st.operands {
+			if isString(op) {
+				out = append(out, op[1:len(op) - 1]...)
+				continue
+			}
+			value, err := a.evalRange(op, -0x80, 0xFF)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, byte(value))
+		}
+		return out, nil
+	case "DW":
+		var out []byte
+		for _, op := range st.operands {
+			value, err := a.evalRange(op, -0x8000, 0xFFFF)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, byte(value >> 8), byte(value))
+		}
+		return out, nil
+	}
+	opcode := st.def.Pattern
+	var long uint16
+	for idx, placeholder := range st.def.Operands() {
+		op := st.operands[idx]
+		switch placeholder {
+		case "Vx":
+			opcode |= uint16(registerIndex(op)) << 8
+		case "Vy":
+			opcode |= uint16(registerIndex(op)) << 4
+		case "byte":
+			value, err := a.evalRange(op, -0x80, 0xFF)
+			if err != nil {
+				return nil, err
+			}
+			opcode |= uint16(value) & 0xFF
+		case "addr":
+			value, err := a.evalRange(op, 0, 0xFFF)
+			if err != nil {
+				return nil, err
+			}
+			opcode |= uint16(value)
+		case "nibble":
+			value, err := a.evalRange(op, 0, 0xF)
+			if err != nil {
+				return nil, err
+			}
+			opcode |= uint16(value)
+		case "plane":
+			value, err := a.evalRange(op, 0, 0xF)
+			if err != nil {
+				return nil, err
+			}
+			opcode |= uint16(value) << 8
+		case "long":
+			value, err := a.evalRange(strings.TrimSpace(op[len("long"):]), 0, 0xFFFF)
+			if err != nil {
+				return nil, err
+			}
+			long = uint16(value)
+		}
+	}
+	out := []byte{byte(opcode >> 8), byte(opcode)}
+	if st.def.Size == 4 {
+		out = append(out, byte(long >> 8), byte(long))
+	}
+	return out, nil
+}
+
+func (a *assembler) evalRange(expr string, lo, hi int) (int, error) {
+	value, err := a.eval(expr)
+	if err != nil {
+		return 0, err
+	}
+	if value < lo || value > hi {
+		return 0, fmt.Errorf("value %d of '%s' out of range [%d, %d]", value, expr, lo, hi)
+	}
+	return value, nil
+}
+
+// eval computes a sum of numbers, labels and constants.
+func (a *assembler) eval(expr string) (int, error) {
+	expr = strings.TrimSpace(expr)
+	if len(expr) == 0 {
+		return 0, fmt.Errorf("missing operand")
+	}
+	total := 0
+	sign := 1
+	start := 0
+	for idx := 0; idx <= len(expr); idx++ {
+		if idx < len(expr) && (expr[idx] != '+' && expr[idx] != '-' || idx == start) {
+			continue
+		}
+		term := strings.TrimSpace(expr[start:idx])
+		termSign := sign
+		// Unary minus
+		if strings.HasPrefix(term, "-") {
+			termSign, term = -termSign, strings.TrimSpace(term[1:])
+		}
+		value, err := a.term(term)
+		if err != nil {
+			return 0, err
+		}
+		total += termSign * value
+		if idx < len(expr) {
+			sign = 1
+			if expr[idx] == '-' {
+				sign = -1
+			}
+		}
+		start = idx + 1
+	}
+	return total, nil
+}
+
+func (a *assembler) term(term string) (int, error) {
+	if len(term) == 0 {
+		return 0, fmt.Errorf("missing term")
+	}
+	if value, ok := parseNumber(term); ok {
+		return value, nil
+	}
+	if addr, ok := a.labels[term]; ok {
+		return int(addr), nil
+	}
+	if _, ok := a.constants[term]; ok {
+		return a.resolve(term)
+	}
+	return 0, fmt.Errorf("undefined symbol '%s'", term)
+}
+
+func (a *assembler) resolve(name string) (int, error) {
+	if a.resolving[name] {
+		return 0, fmt.Errorf("constant '%s' is defined in terms of itself", name)
+	}
+	a.resolving[name] = true
+	defer delete(a.resolving, name)
+	return a.eval(a.constants[name].expr)
+}
+
+// parseNumber reads decimal, 0x/$/# hex and 0b binary numbers.
+func parseNumber(s string) (int, bool) {
+	base := 10
+	digits := s
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		base, digits = 16, s[2:]
+	case strings.HasPrefix(s, "$"), strings.HasPrefix(s, "#"):
+		base, digits = 16, s[1:]
+	case strings.HasPrefix(lower, "0b"):
+		base, digits = 2, s[2:]
+	}
+	value, err := strconv.ParseInt(digits, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int(value), true
+}
+
+func registerIndex(op string) byte {
+	value, _ := strconv.ParseUint(op[1:], 16, 8)
+	return byte(value)
+}
+
+func isString(op string) bool {
+	return len(op) >= 2 && op[0] == '"' && op[len(op) - 1] == '"'
+}
+
+func isLong(op string) bool {
+	fields := strings.Fields(op)
+	return len(fields) == 2 && strings.EqualFold(fields[0], "long")
+}
+
+// stripComment removes everything after a ';' outside of a string.
+func stripComment(line string) string {
+	inString := false
+	for idx, c := range line {
+		switch {
+		case c == '"':
+			inString = !inString
+		case c == ';' && !inString:
+			return line[:idx]
+		}
+	}
+	return line
+}
+
+// splitOperands splits on commas outside of strings.
+func splitOperands(s string) []string {
+	var operands []string
+	inString := false
+	start := 0
+	for idx, c := range s {
+		switch {
+		case c == '"':
+			inString = !inString
+		case c == ',' && !inString:
+			operands = append(operands, strings.TrimSpace(s[start:idx]))
+			start = idx + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); len(last) > 0 || len(operands) > 0 {
+		operands = append(operands, last)
+	}
+	return operands
+}
@@ -0,0 +1,130 @@
+package asm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []byte
+	}{
+		{"instructions", "CLS\nLD V1, 0x2A\nDRW V0, V1, 5\nRET", []byte{0x00, 0xE0, 0x61, 0x2A, 0xD0, 0x15, 0x00, 0xEE}},
+		{"lowercase mnemonics", "ld va, 10\nadd i, vb", []byte{0x6A, 0x0A, 0xFB, 0x1E}},
+		{"backward label", "loop:\n  JP loop", []byte{0x12, 0x00}},
+		{"forward label", "CALL sub\nsub: RET", []byte{0x22, 0x02, 0x00, 0xEE}},
+		{"label arithmetic", "JP end + 2\nend:", []byte{0x12, 0x04}},
+		{"constant", "SPEED = 3\nLD V0, SPEED", []byte{0x60, 0x03}},
+		{"equ constant", "SPEED equ 0x10\nLD V0, SPEED - 1", []byte{0x60, 0x0F}},
+		{"constant defined later", "LD V0, X\nX = Y + 1\nY = 4", []byte{0x60, 0x05}},
+		{"number bases", "db 10, 0x10, $10, #10, 0b101, -1", []byte{10, 0x10, 0x10, 0x10, 5, 0xFF}},
+		{"db string", `db "HI", 0`, []byte{'H', 'I', 0}},
+		{"dw", "dw 0x1234, data\ndata:", []byte{0x12, 0x34, 0x02, 0x04}},
+		{"jump with V0", "JP V0, table\ntable: db 1", []byte{0xB2, 0x02, 0x01}},
+		{"long load", "LD I, long 0x1234", []byte{0xF0, 0x00, 0x12, 0x34}},
+		{"optional shift register", "SHR V3", []byte{0x83, 0x36}},
+		{"store and load", "LD [I], V4\nLD V4, [I]", []byte{0xF4, 0x55, 0xF4, 0x65}},
+		{"org", "CLS\norg 0x206\nRET", []byte{0x00, 0xE0, 0, 0, 0, 0, 0x00, 0xEE}},
+		{"comments", "; header\nCLS ; clear\ndb \";\" ; semicolon", []byte{0x00, 0xE0, ';'}},
+		{"listing columns", "0200: 00E0  CLS\n0202: 61FF  LD V1, 0xFF", []byte{0x00, 0xE0, 0x61, 0xFF}},
+		{"listing data", "0200:      db 0x12\n0201: 00E0 CLS", []byte{0x12, 0x00, 0xE0}},
+		{"hex word labels", "beef: CLS\nface:\nadd1: JP beef\nCALL face", []byte{0x00, 0xE0, 0x12, 0x00, 0x22, 0x02}},
+		{"org into a gap", "org 0x204\nRET\norg 0x200\nCLS", []byte{0x00, 0xE0, 0, 0, 0x00, 0xEE}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			program, err := AssembleSource("test.asm", tc.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(program.Bytes, tc.want) {
+				t.Errorf("got % X, want % X", program.Bytes, tc.want)
+			}
+		})
+	}
+}
+
+func TestAssembleSymbols(t *testing.T) {
+	program, err := AssembleSource("test.asm", "SIZE = 8\nstart: CLS\nend: RET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint16{"SIZE": 8, "start": 0x200, "end": 0x202}
+	for name, value := range want {
+		if program.Symbols[name] != value {
+			t.Errorf("symbol %s = 0x%X, want 0x%X", name, program.Symbols[name], value)
+		}
+	}
+	var symbols bytes.Buffer
+	if err := program.WriteSymbols(&symbols); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := symbols.String(), "0008 SIZE\n0200 start\n0202 end\n"; got != want {
+		t.Errorf("symbol map %q, want %q", got, want)
+	}
+}
+
+func TestAssembleInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"main.asm":        "include \"lib/sprites.asm\"\nLD I, sprite",
+		"lib/sprites.asm": "include \"consts.asm\"\nJP skip\nsprite: db ROWS\nskip:",
+		"lib/consts.asm":  "ROWS = 0xF0",
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	program, err := AssembleFile(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x12, 0x03, 0xF0, 0xA2, 0x02}; !bytes.Equal(program.Bytes, want) {
+		t.Errorf("got % X, want % X", program.Bytes, want)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"unknown instruction", "CLS\nFOO V1", "test.asm:2: unknown instruction 'FOO V1'"},
+		{"undefined symbol", "CLS\n\nJP nowhere", "test.asm:3: undefined symbol 'nowhere'"},
+		{"duplicate label", "a: CLS\na: RET", "test.asm:2: 'a' already defined"},
+		{"reserved name", "DT = 1", "test.asm:1: 'DT' is a reserved name"},
+		{"register name", "V3: CLS", "test.asm:1: 'V3' is a reserved name"},
+		{"byte out of range", "LD V0, 256", "test.asm:1: value 256 of '256' out of range [-128, 255]"},
+		{"nibble out of range", "DRW V0, V1, 16", "test.asm:1: value 16 of '16' out of range [0, 15]"},
+		{"address out of range", "JP 0x1000", "test.asm:1: value 4096 of '0x1000' out of range [0, 4095]"},
+		{"self-referencing constant", "X = Y\nY = X\nLD V0, X", "is defined in terms of itself"},
+		{"org below program", "org 0x100", "test.asm:1: org address 0x100 outside of program space"},
+		{"listing address mismatch", "0202: 00E0  CLS", "test.asm:1: listing address 0202 does not match assembled address 0200"},
+		{"letter listing address mismatch", "ABCD: 00E0  CLS", "test.asm:1: listing address ABCD does not match assembled address 0200"},
+		{"org overlap", "CLS\nRET\norg 0x202\nLD V0, 1", "test.asm:4: output at 0x202 overlaps the output of test.asm:2"},
+		{"org overlap inside data", "db 1, 2, 3, 4\norg 0x201\nCLS", "test.asm:3: output at 0x201 overlaps the output of test.asm:1"},
+		{"missing include", `include "missing.asm"`, "test.asm:1: unable to include"},
+		{"include without file", "include", "test.asm:1: include expects a file name"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := AssembleSource("test.asm", tc.source)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tc.want)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %q does not contain %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -13,30 +14,45 @@ const (
 	ProgramReadOffsetBytes = 0
 	ProgramStoreOffsetBytes = 512
 	SpriteStartOffsetBytes = 0
+	BigSpriteStartOffsetBytes = 80
 	StartAddr = 0x200
 )
 
 type Instruction struct {
 	Opcode uint16
 	Address uint16
+	Op Op
 	Name string
 	LeftOp string
 	RightOp string
+	// Size in bytes
+	Size int
 }
 
-func (inst *Instruction) Print() {
-	fmtStr := fmt.Sprintf("%04X: %04x %s", inst.Address, inst.Opcode, inst.Name)
+// String formats the instruction as "NAME LeftOp, RightOp".
+func (inst *Instruction) String() string {
+	fmtStr := inst.Name
 	if len(inst.LeftOp) > 0 {
-		fmtStr = fmt.Sprintf("%s  %s", fmtStr, inst.LeftOp)
+		fmtStr = fmt.Sprintf("%s %s", fmtStr, inst.LeftOp)
 		if len(inst.RightOp) > 0 {
-			fmtStr = fmt.Sprintf("%s,%s", fmtStr, inst.RightOp)
+			fmtStr = fmt.Sprintf("%s, %s", fmtStr, inst.RightOp)
 		}
 	}
-	fmt.Printf("%s\n", fmtStr)
+	return fmtStr
+}
+
+// Line formats the instruction as a listing line with its address and opcode.
+func (inst *Instruction) Line() string {
+	return fmt.Sprintf("%04X: %04X %s", inst.Address, inst.Opcode, inst.String())
+}
+
+func (inst *Instruction) Print() {
+	fmt.Println(inst.Line())
 }
 
 type Opcode struct {
 	Opcode uint16
+	Op Op
 	NibbleLower byte
 	NibbleY byte 
 	NibbleX byte
@@ -59,6 +75,7 @@ func ParseOpcode(opcode uint16) *Opcode {
 	addr := extractAddress(opcode)
 	return &Opcode{
 		Opcode: opcode,
+		Op: decodeOp(opcode),
 		NibbleLower: nibbleLower,
 		NibbleY: nibbleY,
 		NibbleX: nibbleX,
@@ -88,63 +105,46 @@ func UnknownOpcodeErr(opcode uint16) error {
 }
 
 
-func parseInstructionFromOpcode(opcode Opcode, instruction *Instruction) {
-	switch opcode.NibbleUpper {
-		case 0x00: {
-			switch opcode.LowerByte {
-			case 0xE0: {
-				instruction.Name = "CLS"
-			}
-			case 0xEE: {
-				instruction.Name = "RET"	
-			}
-			default: instruction.Name = "UNK 0"
-			}
-		}
-		case 0x01: {
-			instruction.Name = "JP"
-			leftOp := opcode.Addr
-			instruction.LeftOp = fmt.Sprintf("%X", leftOp)
-		}
-		case 0x06: {
-			instruction.Name = "LD"
-			leftOp := opcode.NibbleX
-			rightOp := opcode.LowerByte
-			instruction.LeftOp = fmt.Sprintf("V%d", leftOp)
-			instruction.RightOp = fmt.Sprintf("%X", rightOp)
-		}
-		case 0x07: {
-			instruction.Name = "ADD"
-			leftOp := opcode.NibbleX
-			rightOp := opcode.LowerByte
-			instruction.LeftOp = fmt.Sprintf("V%d", leftOp)
-			instruction.RightOp = fmt.Sprintf("%X", rightOp)	
-		}
-		case 0x0A: {
-			instruction.Name = "LD"
-			rightOp := opcode.Addr
-			instruction.LeftOp = "I"
-			instruction.RightOp = fmt.Sprintf("%X", rightOp)
-		}
-		case 0x0D: {
-			instruction.Name = "DRW"
-			x := opcode.NibbleX
-			y := opcode.NibbleY
-			n := opcode.NibbleLower
-			instruction.LeftOp = fmt.Sprintf("V%d", x)
-			instruction.RightOp = fmt.Sprintf("V%d,%d", y, n)
-		}
-		default: instruction.Name = "UNK"
+func decodeOp(opcode uint16) Op {
+	if def := Decode(opcode); def != nil {
+		return def.Op
 	}
+	return OpUnknown
 }
 
-
+// ParseHexInstruction decodes the instruction at offset idx of a program.
 func ParseHexInstruction(inst []byte, idx int) Instruction {
-	var instruction Instruction
+	return DecodeInstruction(inst, StartAddr + uint16(idx))
+}
+
+// DecodeInstruction decodes the instruction at the start of inst, located
+// at addr in memory. Instructions that do not fit in inst decode as UNK.
+func DecodeInstruction(inst []byte, addr uint16) Instruction {
+	instruction := Instruction{Address: addr, Name: "UNK", Size: 2}
+	if len(inst) < 2 {
+		return instruction
+	}
 	instruction.Opcode = binary.BigEndian.Uint16(inst)
 	opcode := ParseOpcode(instruction.Opcode)
-	instruction.Address = StartAddr + uint16(idx)
-	parseInstructionFromOpcode(*opcode, &instruction)
+	def := Decode(instruction.Opcode)
+	if def == nil || len(inst) < def.Size {
+		return instruction
+	}
+	var long uint16
+	if def.Size == 4 {
+		long = binary.BigEndian.Uint16(inst[2:])
+	}
+	var ops []string
+	for _, placeholder := range def.Operands() {
+		ops = append(ops, formatOperand(placeholder, opcode, long))
+	}
+	instruction.Op = def.Op
+	instruction.Name = def.Mnemonic()
+	instruction.Size = def.Size
+	if len(ops) > 0 {
+		instruction.LeftOp = ops[0]
+		instruction.RightOp = strings.Join(ops[1:], ", ")
+	}
 	return instruction
 }
 
@@ -168,11 +168,10 @@ func ReadFile(file string) ([]byte, error) {
 		return nil, fmt.Errorf("unable to find program start '%s': %v", file, err)
 	}
 	content := make([]byte, fs.Size() - ProgramReadOffsetBytes)
-	n, err := f.Read(content)
+	_, err = io.ReadFull(f, content)
 	if err != nil {
 		return content, fmt.Errorf("unable to read file '%s': %v", file, err)
 	}
-	fmt.Printf("Read %d bytes\n", n)
 	return content, nil
 }
 
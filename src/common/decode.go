@@ -0,0 +1,192 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op identifies an instruction independently of its operands.
+type Op uint8
+
+const (
+	OpUnknown Op = iota
+	OpCLS
+	OpRET
+	OpSCD
+	OpSCU
+	OpSCR
+	OpSCL
+	OpEXIT
+	OpLOW
+	OpHIGH
+	OpSYS
+	OpJP
+	OpCALL
+	OpSEVal
+	OpSNEVal
+	OpSE
+	OpSAVE
+	OpLOAD
+	OpLDVal
+	OpADDVal
+	OpLD
+	OpOR
+	OpAND
+	OpXOR
+	OpADD
+	OpSUB
+	OpSHR
+	OpSUBN
+	OpSHL
+	OpSNE
+	OpLDI
+	OpJPV0
+	OpRND
+	OpDRW
+	OpSKP
+	OpSKNP
+	OpLDILong
+	OpPLANE
+	OpAUDIO
+	OpLDVxDT
+	OpLDKEY
+	OpLDDT
+	OpLDST
+	OpADDI
+	OpLDF
+	OpLDHF
+	OpLDB
+	OpPITCH
+	OpSTR
+	OpLDR
+	OpSTRRPL
+	OpLDRPL
+)
+
+// OpDef describes how an instruction is encoded and disassembled.
+// Syntax follows Cowgod's reference, operand placeholders are:
+//
+//	Vx, Vy  registers from the x and y nibbles
+//	byte    the lower byte
+//	addr    the lower 12 bits
+//	nibble  the lowest nibble
+//	plane   the x nibble as a plane mask
+//	long    the 16-bit word following the opcode, written "long nnnn"
+type OpDef struct {
+	Op Op
+	Mask uint16
+	Pattern uint16
+	Syntax string
+	// Size in bytes, including any trailing operand words
+	Size int
+}
+
+// Mnemonic is the instruction name without its operands.
+func (d *OpDef) Mnemonic() string {
+	return strings.Fields(d.Syntax)[0]
+}
+
+// Operands lists the operand placeholders of the syntax.
+func (d *OpDef) Operands() []string {
+	_, ops, found := strings.Cut(d.Syntax, " ")
+	if !found {
+		return nil
+	}
+	return strings.Split(ops, ", ")
+}
+
+// OpDefs is the decode table shared by the interpreter, disassembler and
+// assembler. More specific patterns must come before the general ones
+// they overlap with.
+var OpDefs = []OpDef{
+	{OpCLS, 0xFFFF, 0x00E0, "CLS", 2},
+	{OpRET, 0xFFFF, 0x00EE, "RET", 2},
+	{OpSCD, 0xFFF0, 0x00C0, "SCD nibble", 2},
+	{OpSCU, 0xFFF0, 0x00D0, "SCU nibble", 2},
+	{OpSCR, 0xFFFF, 0x00FB, "SCR", 2},
+	{OpSCL, 0xFFFF, 0x00FC, "SCL", 2},
+	{OpEXIT, 0xFFFF, 0x00FD, "EXIT", 2},
+	{OpLOW, 0xFFFF, 0x00FE, "LOW", 2},
+	{OpHIGH, 0xFFFF, 0x00FF, "HIGH", 2},
+	{OpSYS, 0xF000, 0x0000, "SYS addr", 2},
+	{OpJP, 0xF000, 0x1000, "JP addr", 2},
+	{OpCALL, 0xF000, 0x2000, "CALL addr", 2},
+	{OpSEVal, 0xF000, 0x3000, "SE Vx, byte", 2},
+	{OpSNEVal, 0xF000, 0x4000, "SNE Vx, byte", 2},
+	{OpSE, 0xF00F, 0x5000, "SE Vx, Vy", 2},
+	{OpSAVE, 0xF00F, 0x5002, "SAVE Vx, Vy", 2},
+	{OpLOAD, 0xF00F, 0x5003, "LOAD Vx, Vy", 2},
+	{OpLDVal, 0xF000, 0x6000, "LD Vx, byte", 2},
+	{OpADDVal, 0xF000, 0x7000, "ADD Vx, byte", 2},
+	{OpLD, 0xF00F, 0x8000, "LD Vx, Vy", 2},
+	{OpOR, 0xF00F, 0x8001, "OR Vx, Vy", 2},
+	{OpAND, 0xF00F, 0x8002, "AND Vx, Vy", 2},
+	{OpXOR, 0xF00F, 0x8003, "XOR Vx, Vy", 2},
+	{OpADD, 0xF00F, 0x8004, "ADD Vx, Vy", 2},
+	{OpSUB, 0xF00F, 0x8005, "SUB Vx, Vy", 2},
+	{OpSHR, 0xF00F, 0x8006, "SHR Vx, Vy", 2},
+	{OpSUBN, 0xF00F, 0x8007, "SUBN Vx, Vy", 2},
+	{OpSHL, 0xF00F, 0x800E, "SHL Vx, Vy", 2},
+	{OpSNE, 0xF00F, 0x9000, "SNE Vx, Vy", 2},
+	{OpLDI, 0xF000, 0xA000, "LD I, addr", 2},
+	{OpJPV0, 0xF000, 0xB000, "JP V0, addr", 2},
+	{OpRND, 0xF000, 0xC000, "RND Vx, byte", 2},
+	{OpDRW, 0xF000, 0xD000, "DRW Vx, Vy, nibble", 2},
+	{OpSKP, 0xF0FF, 0xE09E, "SKP Vx", 2},
+	{OpSKNP, 0xF0FF, 0xE0A1, "SKNP Vx", 2},
+	{OpLDILong, 0xFFFF, 0xF000, "LD I, long", 4},
+	{OpPLANE, 0xF0FF, 0xF001, "PLANE plane", 2},
+	{OpAUDIO, 0xFFFF, 0xF002, "AUDIO", 2},
+	{OpLDVxDT, 0xF0FF, 0xF007, "LD Vx, DT", 2},
+	{OpLDKEY, 0xF0FF, 0xF00A, "LD Vx, K", 2},
+	{OpLDDT, 0xF0FF, 0xF015, "LD DT, Vx", 2},
+	{OpLDST, 0xF0FF, 0xF018, "LD ST, Vx", 2},
+	{OpADDI, 0xF0FF, 0xF01E, "ADD I, Vx", 2},
+	{OpLDF, 0xF0FF, 0xF029, "LD F, Vx", 2},
+	{OpLDHF, 0xF0FF, 0xF030, "LD HF, Vx", 2},
+	{OpLDB, 0xF0FF, 0xF033, "LD B, Vx", 2},
+	{OpPITCH, 0xF0FF, 0xF03A, "PITCH Vx", 2},
+	{OpSTR, 0xF0FF, 0xF055, "LD [I], Vx", 2},
+	{OpLDR, 0xF0FF, 0xF065, "LD Vx, [I]", 2},
+	{OpSTRRPL, 0xF0FF, 0xF075, "LD R, Vx", 2},
+	{OpLDRPL, 0xF0FF, 0xF085, "LD Vx, R", 2},
+}
+
+// Index into OpDefs (plus one, 0 meaning unknown) for every opcode
+var decodeTable [1 << 16]uint8
+
+func init() {
+	for opcode := range decodeTable {
+		for idx := range OpDefs {
+			if uint16(opcode) & OpDefs[idx].Mask == OpDefs[idx].Pattern {
+				decodeTable[opcode] = uint8(idx + 1)
+				break
+			}
+		}
+	}
+}
+
+// Decode returns the definition matching the opcode, nil if unknown.
+func Decode(opcode uint16) *OpDef {
+	idx := decodeTable[opcode]
+	if idx == 0 {
+		return nil
+	}
+	return &OpDefs[idx - 1]
+}
+
+// formatOperand renders an operand placeholder with the opcode's values,
+// long is the word following the opcode for 4-byte instructions.
+func formatOperand(placeholder string, opcode *Opcode, long uint16) string {
+	switch placeholder {
+	case "Vx": return fmt.Sprintf("V%X", opcode.NibbleX)
+	case "Vy": return fmt.Sprintf("V%X", opcode.NibbleY)
+	case "byte": return fmt.Sprintf("0x%02X", opcode.LowerByte)
+	case "addr": return fmt.Sprintf("0x%03X", opcode.Addr)
+	case "nibble": return fmt.Sprintf("%d", opcode.NibbleLower)
+	case "plane": return fmt.Sprintf("%d", opcode.NibbleX)
+	case "long": return fmt.Sprintf("long 0x%04X", long)
+	}
+	// Fixed operands such as I, DT or [I]
+	return placeholder
+}
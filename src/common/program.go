@@ -0,0 +1,66 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AssembledProgram is a ROM built from source along with its symbols.
+type AssembledProgram struct {
+	Bytes []byte
+	// Labels and constants by name
+	Symbols map[string]uint16
+}
+
+// WriteSymbols writes one "ADDR NAME" line per symbol, sorted by value.
+func (p *AssembledProgram) WriteSymbols(w io.Writer) error {
+	names := make([]string, 0, len(p.Symbols))
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if p.Symbols[names[i]] != p.Symbols[names[j]] {
+			return p.Symbols[names[i]] < p.Symbols[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		if _, err := fmt.Fprintf(w, "%04X %s\n", p.Symbols[name], name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OutputPaths fills in the ROM and symbol map paths of a build left
+// empty. The ROM defaults to the source name with a .ch8 extension and
+// the symbol map to the ROM name with a .sym extension.
+func OutputPaths(source, rom, symbols string) (string, string) {
+	if len(rom) == 0 {
+		rom = strings.TrimSuffix(source, filepath.Ext(source)) + ".ch8"
+	}
+	if len(symbols) == 0 {
+		symbols = strings.TrimSuffix(rom, filepath.Ext(rom)) + ".sym"
+	}
+	return rom, symbols
+}
+
+// WriteFiles writes the ROM and its symbol map.
+func (p *AssembledProgram) WriteFiles(romPath, symbolsPath string) error {
+	if err := os.WriteFile(romPath, p.Bytes, 0644); err != nil {
+		return fmt.Errorf("unable to write '%s': %v", romPath, err)
+	}
+	f, err := os.Create(symbolsPath)
+	if err != nil {
+		return fmt.Errorf("unable to create symbol map '%s': %v", symbolsPath, err)
+	}
+	if err := p.WriteSymbols(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write symbol map '%s': %v", symbolsPath, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+var program = []byte{
+	0x60, 0x05, // 200: LD V0, 0x05
+	0xA3, 0x00, // 202: LD I, 0x300
+	0xF0, 0x55, // 204: LD [I], V0
+	0x22, 0x0C, // 206: CALL 0x20C
+	0x70, 0x01, // 208: ADD V0, 0x01
+	0x00, 0xFD, // 20A: EXIT
+	0x6F, 0x01, // 20C: LD VF, 0x01
+	0x00, 0xEE, // 20E: RET
+}
+
+func newVM(tracer *interpreter.Tracer) *interpreter.VirtualMachine {
+	vm := &interpreter.VirtualMachine{
+		Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+		Tracer:  tracer,
+	}
+	vm.Init(program, 700)
+	return vm
+}
+
+func TestParseState(t *testing.T) {
+	s, err := parseState(strings.Fields("PC=0206 op=8014 V0=12 vf=01 I=0300 SP=1 DT=3c ST=0 @0300=12 @fff=ff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.pc != 0x206 || s.op != 0x8014 || s.v[0] != 0x12 || s.v[0xF] != 1 || s.i != 0x300 || s.sp != 1 || s.dt != 0x3C || s.st != 0 {
+		t.Errorf("got %+v", s)
+	}
+	if s.v[1] != -1 {
+		t.Errorf("V1 = %d, want -1 for an omitted field", s.v[1])
+	}
+	if len(s.memory) != 2 || s.memory[0x300] != 0x12 || s.memory[0xFFF] != 0xFF {
+		t.Errorf("memory = %v", s.memory)
+	}
+	errors := []struct {
+		field string
+		want  string
+	}{
+		{"PC", "expected KEY=VALUE, got 'PC'"},
+		{"PC=xyz", "invalid value 'xyz' for PC"},
+		{"PC=10000", "invalid value '10000' for PC"},
+		{"V0=100", "value '100' of V0 out of range"},
+		{"@300=100", "invalid memory field '@300=100'"},
+		{"@zz=1", "invalid memory field '@zz=1'"},
+		{"VG=1", "unknown field 'VG'"},
+		{"R=1", "unknown field 'R'"},
+	}
+	for _, tc := range errors {
+		if _, err := parseState([]string{tc.field}); err == nil || err.Error() != tc.want {
+			t.Errorf("parseState(%q) = %v, want %q", tc.field, err, tc.want)
+		}
+	}
+}
+
+func TestReadReference(t *testing.T) {
+	ref := "# header\n\nPC=200 OP=6005 V0=05\n  PC=202 I=300  # comment\n"
+	states, err := readReference(strings.NewReader(ref))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].line != 3 || states[0].v[0] != 5 || states[1].line != 4 || states[1].i != 0x300 {
+		t.Errorf("got states %+v %+v", states[0], states[1])
+	}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"PC=200\nPC=202 X=1\n", "line 2: unknown field 'X'"},
+		{"1 0200: 6005 LD V0, 0x05  V0=0x05\n", "line 1: expected REG=OLD->NEW, got 'V0=0x05'"},
+		{"{\"pc\":512,\n", "line 1: invalid JSON trace record"},
+	}
+	for _, tc := range tests {
+		if _, err := readReference(strings.NewReader(tc.ref)); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("readReference(%q) = %v, want %q", tc.ref, err, tc.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ReferenceFile = "test.ref"
+	tests := []struct {
+		name string
+		ref  string
+		// Lines expected in the output, in order, none when the VM
+		// matches the reference
+		want []string
+	}{
+		{
+			name: "match",
+			ref:  "PC=200 OP=6005 V0=05\nPC=202 I=0300\nPC=204 @0300=05\nPC=206 SP=1\nPC=20C VF=01\n",
+		},
+		{
+			name: "register",
+			ref:  "PC=200 V0=05\nPC=202 I=0301 V1=00 DT=00\n",
+			want: []string{`Divergence after 2 instructions, at line 2 of test.ref: the state after the instruction differs
+Last instruction: 0202: A300 LD I, 0x300
+         VM reference
+V0       05        --
+V1       00        00
+V2       00        --
+V3       00        --
+V4       00        --
+V5       00        --
+V6       00        --
+V7       00        --
+V8       00        --
+V9       00        --
+VA       00        --
+VB       00        --
+VC       00        --
+VD       00        --
+VE       00        --
+VF       00        --
+I      0300      0301 *
+SP       00        --
+DT       00        00
+ST       00        --
+`},
+		},
+		{
+			name: "memory",
+			ref:  "PC=200\nPC=202\nPC=204 @0300=06 @0301=00\n",
+			want: []string{
+				"Divergence after 3 instructions, at line 3 of test.ref: the state after the instruction differs\n",
+				"Last instruction: 0204: F055 LD [I], V0\n",
+				"ST       00        --\nMemory 0x300: VM 0x05, reference 0x06\n",
+			},
+		},
+		{
+			name: "address",
+			ref:  "PC=200\nPC=202 I=0300\nPC=206\n",
+			want: []string{
+				"Divergence after 2 instructions, at line 3 of test.ref: the reference runs 0x206 next, the VM 0x204\n",
+				"Last instruction: 0202: A300 LD I, 0x300\n",
+				"I      0300      0300\n",
+			},
+		},
+		{
+			name: "opcode",
+			ref:  "PC=200 OP=6006\n",
+			want: []string{"Divergence after 0 instructions, at line 1 of test.ref: the reference runs opcode 6006 at 0x200, the VM 6005\n         VM reference\n"},
+		},
+		{
+			name: "end",
+			ref:  "PC=200\nPC=202\nPC=204\nPC=206\nPC=20C\nPC=20E\nPC=208\nPC=20A\nPC=20C V0=06\n",
+			want: []string{
+				"Divergence after 8 instructions, at line 9 of test.ref: the program ended in the VM\n",
+				"Last instruction: 020A: 00FD EXIT\n",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			states, err := readReference(strings.NewReader(tc.ref))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			matched, err := compare(&out, newVM(nil), states)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != (len(tc.want) == 0) {
+				t.Errorf("matched = %v, output:\n%s", matched, out.String())
+			}
+			rest := out.String()
+			for _, want := range tc.want {
+				idx := strings.Index(rest, want)
+				if idx < 0 {
+					t.Fatalf("got output:\n%s\nwant it to contain, after the previous lines:\n%s", out.String(), want)
+				}
+				rest = rest[idx+len(want):]
+			}
+		})
+	}
+}
+
+// Traces written by the VM are references for the same program.
+func TestCompareTraces(t *testing.T) {
+	ReferenceFile = "trace"
+	for _, format := range []int{interpreter.TraceText, interpreter.TraceJSONL} {
+		var trace bytes.Buffer
+		vm := newVM(interpreter.NewTracer(&trace, format))
+		if _, err := vm.RunCycles(100); err != nil {
+			t.Fatal(err)
+		}
+		vm.Tracer.Close()
+		states, err := readReference(bytes.NewReader(trace.Bytes()))
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if len(states) != 8 {
+			t.Errorf("format %d: got %d states, want the 8 instructions up to the exit", format, len(states))
+		}
+		var out bytes.Buffer
+		if matched, err := compare(&out, newVM(nil), states); err != nil || !matched {
+			t.Errorf("format %d: matched = %v, %v, output:\n%s", format, matched, err, out.String())
+		}
+		// A VM behaving differently diverges from the trace
+		other := newVM(nil)
+		other.WriteMemory(0x20D, []byte{0x02})
+		out.Reset()
+		if matched, err := compare(&out, other, states); err != nil || matched {
+			t.Errorf("format %d: matched = %v, %v after changing the program", format, matched, err)
+		}
+		if want := "the reference runs opcode 6F01 at 0x20C, the VM 6F02\nLast instruction: 0206: 220C CALL 0x20C"; !strings.Contains(out.String(), want) {
+			t.Errorf("format %d: got output:\n%s\nwant it to contain:\n%s", format, out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// compare runs a ROM alongside a reference trace recorded by another
+// interpreter, and stops at the first instruction where they diverge.
+//
+// A reference trace has one line per executed instruction, made of
+// KEY=VALUE fields with hexadecimal values:
+//
+//	PC=0206 OP=8014 V0=12 VF=01 I=0300 @0300=12  # comment
+//
+// PC and OP are the address and opcode of the instruction. V0-VF, I, SP,
+// DT and ST are the registers after it ran, and @ADDR fields the memory
+// bytes after it ran, typically those it wrote. Fields can be omitted and
+// are then not checked, so timers can be left out when the interpreters
+// tick them at different times. Empty lines and # comments are ignored.
+//
+// Text and JSONL traces written with -trace are accepted as well, checking
+// the address, the opcode and the registers each instruction changed, so
+// that runs of two versions of the VM can be compared.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	common "github.com/abhinand20/emugo/common"
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+var InputFile string
+var ReferenceFile string
+var ClkSpeed int
+var QuirksPreset string
+
+func initFlags() {
+	flag.StringVar(&InputFile, "file", "", "File containing CHIP-8 hex code.")
+	flag.StringVar(&ReferenceFile, "reference", "", "Reference trace to compare the execution against.")
+	flag.IntVar(&ClkSpeed, "clock_speed", 700, "Clock speed of the emulator in Hz.")
+	flag.StringVar(&QuirksPreset, "quirks", "legacy", fmt.Sprintf("Platform quirks to emulate, one of: %s. legacy keeps the behaviour of earlier versions.", strings.Join(interpreter.QuirkPresetNames(), ", ")))
+}
+
+func validateFlags() error {
+	if len(InputFile) == 0 {
+		return fmt.Errorf("input file not provided")
+	}
+	if len(ReferenceFile) == 0 {
+		return fmt.Errorf("reference trace not provided")
+	}
+	if _, err := interpreter.QuirksPreset(QuirksPreset); err != nil {
+		return err
+	}
+	return nil
+}
+
+// regRow is a register in the side by side register files.
+type regRow struct {
+	name string
+	vm int
+	// -1 when the reference does not have it
+	ref int
+	digits int
+}
+
+func registerRows(regs interpreter.Registers, ref *refState) []regRow {
+	var rows []regRow
+	for idx := range regs.V {
+		rows = append(rows, regRow{fmt.Sprintf("V%X", idx), int(regs.V[idx]), ref.v[idx], 2})
+	}
+	return append(rows,
+		regRow{"I", int(regs.I), ref.i, 4},
+		regRow{"SP", int(regs.SP), ref.sp, 2},
+		regRow{"DT", int(regs.DT), ref.dt, 2},
+		regRow{"ST", int(regs.ST), ref.st, 2},
+	)
+}
+
+func decode(vm *interpreter.VirtualMachine, addr uint16) common.Instruction {
+	data, err := vm.ReadMemory(int(addr), min(4, vm.MemorySize() - int(addr)))
+	if err != nil {
+		return common.Instruction{Address: addr, Name: "UNK", Size: 2}
+	}
+	return common.DecodeInstruction(data, addr)
+}
+
+// report prints a divergence at a line of the reference with the last
+// instruction run, both register files and the memory bytes that differ
+// from the reference state.
+func report(w io.Writer, vm *interpreter.VirtualMachine, count, line int, ref *refState, inst *common.Instruction, reason string) {
+	fmt.Fprintf(w, "Divergence after %d instructions, at line %d of %s: %s\n", count, line, ReferenceFile, reason)
+	if inst != nil {
+		fmt.Fprintf(w, "Last instruction: %s\n", inst.Line())
+	}
+	fmt.Fprintf(w, "%-4s %6s %9s\n", "", "VM", "reference")
+	for _, row := range registerRows(vm.Registers(), ref) {
+		refText, marker := "--", ""
+		if row.ref >= 0 {
+			refText = fmt.Sprintf("%0*X", row.digits, row.ref)
+			if row.ref != row.vm {
+				marker = " *"
+			}
+		}
+		fmt.Fprintf(w, "%-4s %6s %9s%s\n", row.name, fmt.Sprintf("%0*X", row.digits, row.vm), refText, marker)
+	}
+	for _, addr := range ref.addresses() {
+		if data, err := vm.ReadMemory(addr, 1); err == nil && int(data[0]) != ref.memory[addr] {
+			fmt.Fprintf(w, "Memory 0x%03X: VM 0x%02X, reference 0x%02X\n", addr, data[0], ref.memory[addr])
+		}
+	}
+}
+
+// differs reports whether the VM state after an instruction differs from
+// the fields of the reference.
+func differs(vm *interpreter.VirtualMachine, ref *refState) bool {
+	for _, row := range registerRows(vm.Registers(), ref) {
+		if row.ref >= 0 && row.ref != row.vm {
+			return true
+		}
+	}
+	for addr, value := range ref.memory {
+		data, err := vm.ReadMemory(addr, 1)
+		if err != nil || int(data[0]) != value {
+			return true
+		}
+	}
+	return false
+}
+
+// compare runs the VM through the reference, reporting whether it matched.
+func compare(w io.Writer, vm *interpreter.VirtualMachine, states []*refState) (bool, error) {
+	var last *common.Instruction
+	// State after the last instruction, nothing is known before the first
+	prev, _ := parseState(nil)
+	for count, ref := range states {
+		pc := vm.Registers().PC
+		inst := decode(vm, pc)
+		if ref.pc >= 0 && ref.pc != int(pc) {
+			report(w, vm, count, ref.line, prev, last, fmt.Sprintf("the reference runs 0x%03X next, the VM 0x%03X", ref.pc, pc))
+			return false, nil
+		}
+		if ref.op >= 0 && ref.op != int(inst.Opcode) {
+			report(w, vm, count, ref.line, prev, last, fmt.Sprintf("the reference runs opcode %04X at 0x%03X, the VM %04X", ref.op, pc, inst.Opcode))
+			return false, nil
+		}
+		_, end, err := vm.Cycle()
+		if err != nil {
+			return false, fmt.Errorf("instruction %d at 0x%03X: %v", count + 1, pc, err)
+		}
+		// The VM ends instead of running the instruction
+		if end {
+			report(w, vm, count, ref.line, prev, last, "the program ended in the VM")
+			return false, nil
+		}
+		last = &inst
+		if differs(vm, ref) {
+			report(w, vm, count + 1, ref.line, ref, last, "the state after the instruction differs")
+			return false, nil
+		}
+		prev = ref
+	}
+	return true, nil
+}
+
+func main() {
+	initFlags()
+	flag.Parse()
+	if err := validateFlags(); err != nil {
+		fmt.Printf("err: %v\n", err)
+		return
+	}
+	content, err := common.ReadFile(InputFile)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		return
+	}
+	f, err := os.Open(ReferenceFile)
+	if err != nil {
+		fmt.Printf("err: unable to open reference trace '%s': %v\n", ReferenceFile, err)
+		return
+	}
+	states, err := readReference(f)
+	f.Close()
+	if err != nil {
+		fmt.Printf("err: unable to read reference trace '%s': %v\n", ReferenceFile, err)
+		return
+	}
+	quirks, _ := interpreter.QuirksPreset(QuirksPreset)
+	vm := interpreter.VirtualMachine{
+		Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+		Quirks: quirks,
+		MemoryBytes: interpreter.PresetMemorySize(QuirksPreset),
+	}
+	if err := vm.Init(content, ClkSpeed); err != nil {
+		fmt.Printf("err: %v\n", err)
+		os.Exit(1)
+	}
+	matched, err := compare(os.Stdout, &vm, states)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		os.Exit(1)
+	}
+	if !matched {
+		os.Exit(1)
+	}
+	fmt.Printf("No divergence in %d instructions\n", len(states))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// refState is one line of a reference trace. Only the fields present on
+// the line are checked.
+type refState struct {
+	line int
+	pc int
+	op int
+	v [16]int
+	i int
+	sp int
+	dt int
+	st int
+	// Memory bytes after the instruction, by address
+	memory map[int]int
+}
+
+func (s *refState) addresses() []int {
+	var addrs []int
+	for addr := range s.memory {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	return addrs
+}
+
+// readReference parses a reference trace, see the package comment for
+// its format. Lines can also be those of text and JSONL traces written
+// with -trace.
+func readReference(r io.Reader) ([]*refState, error) {
+	var states []*refState
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		var fields []string
+		var err error
+		if strings.HasPrefix(line, "{") {
+			fields, err = jsonFields(line)
+		} else {
+			if idx := strings.Index(line, "#"); idx >= 0 {
+				line = line[:idx]
+			}
+			fields = strings.Fields(line)
+			if len(fields) > 0 && isTraceLine(fields) {
+				fields, err = traceFields(fields)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		state, err := parseState(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		state.line = lineNo
+		states = append(states, state)
+	}
+	return states, scanner.Err()
+}
+
+// isTraceLine reports whether the fields are a line of a text trace,
+// which starts with the cycle and the address of the instruction:
+//
+//	42 0206: 7301 ADD V3, 0x01  V3=0x00->0x01
+func isTraceLine(fields []string) bool {
+	if _, err := strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return false
+	}
+	return len(fields) >= 3 && strings.HasSuffix(fields[1], ":")
+}
+
+// traceFields turns a text trace line into reference fields, checking
+// the registers the instruction changed.
+func traceFields(fields []string) ([]string, error) {
+	result := []string{"PC=" + strings.TrimSuffix(fields[1], ":"), "OP=" + fields[2]}
+	for _, field := range fields[3:] {
+		key, change, ok := strings.Cut(field, "=")
+		if !ok {
+			// Disassembly
+			continue
+		}
+		_, to, ok := strings.Cut(change, "->")
+		if !ok {
+			return nil, fmt.Errorf("expected REG=OLD->NEW, got '%s'", field)
+		}
+		result = append(result, key + "=" + strings.TrimPrefix(to, "0x"))
+	}
+	return result, nil
+}
+
+// jsonFields turns a JSONL trace line into reference fields, checking
+// the registers the instruction changed.
+func jsonFields(line string) ([]string, error) {
+	var record struct {
+		PC *int `json:"pc"`
+		Opcode *int `json:"opcode"`
+		Changes map[string]int `json:"changes"`
+	}
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return nil, fmt.Errorf("invalid JSON trace record: %v", err)
+	}
+	var fields []string
+	if record.PC != nil {
+		fields = append(fields, fmt.Sprintf("PC=%X", *record.PC))
+	}
+	if record.Opcode != nil {
+		fields = append(fields, fmt.Sprintf("OP=%X", *record.Opcode))
+	}
+	for key, value := range record.Changes {
+		fields = append(fields, fmt.Sprintf("%s=%X", key, value))
+	}
+	return fields, nil
+}
+
+func parseState(fields []string) (*refState, error) {
+	s := &refState{pc: -1, op: -1, i: -1, sp: -1, dt: -1, st: -1, memory: make(map[int]int)}
+	for idx := range s.v {
+		s.v[idx] = -1
+	}
+	for _, field := range fields {
+		key, text, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected KEY=VALUE, got '%s'", field)
+		}
+		value, err := strconv.ParseUint(text, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for %s", text, key)
+		}
+		key = strings.ToUpper(key)
+		if strings.HasPrefix(key, "@") {
+			addr, err := strconv.ParseUint(key[1:], 16, 16)
+			if err != nil || value > 0xFF {
+				return nil, fmt.Errorf("invalid memory field '%s'", field)
+			}
+			s.memory[int(addr)] = int(value)
+			continue
+		}
+		if len(key) == 2 && key[0] == 'V' {
+			if x, err := strconv.ParseUint(key[1:], 16, 8); err == nil {
+				if value > 0xFF {
+					return nil, fmt.Errorf("value '%s' of %s out of range", text, key)
+				}
+				s.v[x] = int(value)
+				continue
+			}
+		}
+		switch key {
+		case "PC": s.pc = int(value)
+		case "OP": s.op = int(value)
+		case "I": s.i = int(value)
+		case "SP": s.sp = int(value)
+		case "DT": s.dt = int(value)
+		case "ST": s.st = int(value)
+		default: return nil, fmt.Errorf("unknown field '%s'", key)
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/abhinand20/emugo/common"
+	"github.com/abhinand20/emugo/octo"
+)
+
+var InputFile string
+var OutputFile string
+var SymbolsFile string
+
+func initFlags() {
+	flag.StringVar(&InputFile, "file", "", "Octo source to compile.")
+	flag.StringVar(&OutputFile, "out", "", "ROM to write, defaults to the source name with a .ch8 extension.")
+	flag.StringVar(&SymbolsFile, "symbols", "", "Symbol map to write, defaults to the ROM name with a .sym extension.")
+}
+
+func validateFlags() error {
+	if len(InputFile) == 0 {
+		return fmt.Errorf("input file not provided")
+	}
+	OutputFile, SymbolsFile = common.OutputPaths(InputFile, OutputFile, SymbolsFile)
+	return nil
+}
+
+func main() {
+	initFlags()
+	flag.Parse()
+	if err := validateFlags(); err != nil {
+		fmt.Printf("err: %v\n", err)
+		return
+	}
+	program, err := octo.CompileFile(InputFile)
+	if err != nil {
+		fmt.Printf("err: %v\n", err)
+		return
+	}
+	if err := program.WriteFiles(OutputFile, SymbolsFile); err != nil {
+		fmt.Printf("err: %v\n", err)
+		return
+	}
+	fmt.Printf("Wrote %d bytes to %s\n", len(program.Bytes), OutputFile)
+}
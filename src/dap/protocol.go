@@ -0,0 +1,108 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// Largest message body accepted, far above what clients send
+const maxMessageSize = 1 << 20
+
+// request is an incoming Debug Adapter Protocol message.
+type request struct {
+	Seq int `json:"seq"`
+	Type string `json:"type"`
+	Command string `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq int `json:"seq"`
+	Type string `json:"type"`
+	RequestSeq int `json:"request_seq"`
+	Success bool `json:"success"`
+	Command string `json:"command"`
+	Message string `json:"message,omitempty"`
+	Body any `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq int `json:"seq"`
+	Type string `json:"type"`
+	Event string `json:"event"`
+	Body any `json:"body,omitempty"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+type breakpoint struct {
+	Verified bool `json:"verified"`
+	Line int `json:"line,omitempty"`
+	Message string `json:"message,omitempty"`
+	Source *source `json:"source,omitempty"`
+}
+
+type stackFrame struct {
+	ID int `json:"id"`
+	Name string `json:"name"`
+	Source *source `json:"source,omitempty"`
+	Line int `json:"line"`
+	Column int `json:"column"`
+	InstructionPointerReference string `json:"instructionPointerReference,omitempty"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+	VariablesReference int `json:"variablesReference"`
+	Expensive bool `json:"expensive"`
+}
+
+type variable struct {
+	Name string `json:"name"`
+	Value string `json:"value"`
+	VariablesReference int `json:"variablesReference"`
+}
+
+// readRequest reads one Content-Length framed message.
+func readRequest(r *bufio.Reader) (*request, error) {
+	headers, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header: %v", err)
+	}
+	if length < 0 || length > maxMessageSize {
+		return nil, fmt.Errorf("invalid Content-Length %d, expected 0 to %d", length, maxMessageSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+	return &req, nil
+}
+
+func writeMessage(w io.Writer, msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
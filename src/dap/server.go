@@ -0,0 +1,477 @@
+// Package dap serves a VirtualMachine over the Debug Adapter Protocol, so
+// that editors such as VS Code can debug a ROM against its disassembly.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	common "github.com/abhinand20/emugo/common"
+	"github.com/abhinand20/emugo/disassembler/disasm"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+const (
+	frameRate = 60
+	// The VM has a single thread of execution
+	threadID = 1
+)
+
+// Variable references of the scopes
+const (
+	registersRef = 1 + iota
+	timersRef
+)
+
+// How the current run ends, besides breakpoints
+const (
+	runContinue = iota
+	runStepIn
+	runStepOver
+	runStepOut
+)
+
+// Server runs one debugging session over a connection.
+type Server struct {
+	vm *interpreter.VirtualMachine
+	conn io.ReadWriter
+	seq int
+	listing *listing
+	breakpoints map[uint16]bool
+	requests chan *request
+	stopOnEntry bool
+	running bool
+	// Set once the program has ended
+	ended bool
+	// Set once the client disconnects
+	disconnected bool
+	// Closed when Serve returns, so that the reader stops handing it requests
+	done chan struct{}
+	mode int
+	// Instructions run since resuming
+	executed int
+	// Step over returns to stopAddr at stopDepth, step out drops below it
+	stopAddr uint16
+	stopDepth uint16
+}
+
+// ListenAndServe writes the listing of program to listingPath, waits
+// for a client on addr, such as ":4711", and serves it until it
+// disconnects.
+func ListenAndServe(vm *interpreter.VirtualMachine, program []byte, listingPath, addr string) error {
+	lst, err := writeListing(listingPath, program)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on '%s': %v", addr, err)
+	}
+	defer listener.Close()
+	fmt.Printf("Waiting for a DAP client on %s, set breakpoints in %s\n", listener.Addr(), lst.path)
+	conn, err := listener.Accept()
+	if err != nil {
+		return fmt.Errorf("unable to accept connection: %v", err)
+	}
+	defer conn.Close()
+	return newServer(vm, lst, conn).Serve()
+}
+
+func newServer(vm *interpreter.VirtualMachine, lst *listing, conn io.ReadWriter) *Server {
+	return &Server{
+		vm: vm,
+		conn: conn,
+		listing: lst,
+		breakpoints: make(map[uint16]bool),
+		requests: make(chan *request),
+		done: make(chan struct{}),
+	}
+}
+
+// Serve handles requests until the client disconnects. The program runs
+// once the client is done configuring, paced at 60Hz like the VM's Run.
+func (s *Server) Serve() error {
+	if s.vm.Input != nil {
+		s.vm.Input.Start()
+		defer s.vm.Input.Stop()
+	}
+	defer close(s.done)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.readRequests()
+	}()
+	frameClk := time.NewTicker(time.Second / frameRate)
+	defer frameClk.Stop()
+	for !s.disconnected {
+		if s.running {
+			if err := s.runFrame(); err != nil {
+				return err
+			}
+		}
+		// Serve requests until the next frame is due
+		for waiting := true; waiting && !s.disconnected; {
+			select {
+			case req := <-s.requests:
+				if err := s.handle(req); err != nil {
+					return err
+				}
+				waiting = s.running
+			case err := <-readErr:
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			case <-frameClk.C:
+				waiting = !s.running
+			}
+		}
+	}
+	return nil
+}
+
+// readRequests hands the requests read from the connection to Serve. It
+// returns nil once Serve is done.
+func (s *Server) readRequests() error {
+	r := bufio.NewReader(s.conn)
+	for {
+		req, err := readRequest(r)
+		if err != nil {
+			return err
+		}
+		select {
+		case s.requests <- req:
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+func (s *Server) send(msg any) error {
+	return writeMessage(s.conn, msg)
+}
+
+func (s *Server) nextSeq() int {
+	s.seq++
+	return s.seq
+}
+
+func (s *Server) respond(req *request, body any) error {
+	return s.send(response{Seq: s.nextSeq(), Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req *request, format string, args ...any) error {
+	return s.send(response{Seq: s.nextSeq(), Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *Server) emit(name string, body any) error {
+	return s.send(event{Seq: s.nextSeq(), Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) handle(req *request) error {
+	switch req.Command {
+	case "initialize":
+		if err := s.respond(req, map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsTerminateRequest": true,
+		}); err != nil {
+			return err
+		}
+		return s.emit("initialized", nil)
+	case "launch", "attach":
+		var args struct {
+			StopOnEntry bool `json:"stopOnEntry"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		s.stopOnEntry = args.StopOnEntry
+		return s.respond(req, nil)
+	case "setBreakpoints":
+		return s.setBreakpoints(req)
+	case "setExceptionBreakpoints":
+		return s.respond(req, map[string]any{"breakpoints": []breakpoint{}})
+	case "configurationDone":
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		if s.stopOnEntry {
+			return s.stop("entry")
+		}
+		s.resume(runContinue)
+		return nil
+	case "threads":
+		return s.respond(req, map[string]any{"threads": []map[string]any{{"id": threadID, "name": "CHIP-8"}}})
+	case "stackTrace":
+		frames := s.stackTrace()
+		return s.respond(req, map[string]any{"stackFrames": frames, "totalFrames": len(frames)})
+	case "scopes":
+		return s.respond(req, map[string]any{"scopes": []scope{
+			{Name: "Registers", VariablesReference: registersRef},
+			{Name: "Timers", VariablesReference: timersRef},
+		}})
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		return s.respond(req, map[string]any{"variables": s.variables(args.VariablesReference)})
+	case "continue", "next", "stepIn", "stepOut":
+		if s.ended {
+			return s.fail(req, "the program has ended")
+		}
+		if err := s.respond(req, map[string]any{"allThreadsContinued": true}); err != nil {
+			return err
+		}
+		s.resume(map[string]int{"continue": runContinue, "next": runStepOver, "stepIn": runStepIn, "stepOut": runStepOut}[req.Command])
+		return nil
+	case "pause":
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		if s.running {
+			return s.stop("pause")
+		}
+		return nil
+	case "terminate":
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		s.running = false
+		return s.emit("terminated", nil)
+	case "disconnect":
+		s.disconnected = true
+		return s.respond(req, nil)
+	}
+	return s.fail(req, "unsupported request '%s'", req.Command)
+}
+
+func (s *Server) setBreakpoints(req *request) error {
+	var args struct {
+		Source source `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.fail(req, "invalid arguments: %v", err)
+	}
+	// The request replaces all breakpoints of the source
+	result := make([]breakpoint, len(args.Breakpoints))
+	ours := s.listing.matches(args.Source.Path)
+	if ours {
+		s.breakpoints = make(map[uint16]bool)
+	}
+	for idx, bp := range args.Breakpoints {
+		addr, ok := s.listing.address(bp.Line)
+		switch {
+		case !ours:
+			result[idx].Message = fmt.Sprintf("breakpoints can only be set in %s", s.listing.path)
+		case !ok:
+			result[idx].Message = "no instruction on this line"
+		default:
+			s.breakpoints[addr] = true
+			result[idx] = breakpoint{Verified: true, Line: bp.Line, Source: s.listing.source()}
+		}
+	}
+	return s.respond(req, map[string]any{"breakpoints": result})
+}
+
+func (s *Server) resume(mode int) {
+	regs := s.vm.Registers()
+	s.mode = mode
+	s.executed = 0
+	s.running = true
+	switch mode {
+	case runStepOver:
+		if inst := s.decode(regs.PC); inst.Op == common.OpCALL {
+			s.stopAddr, s.stopDepth = regs.PC + uint16(inst.Size), regs.SP
+		} else {
+			s.mode = runStepIn
+		}
+	case runStepOut:
+		s.stopDepth = regs.SP
+		// Outside of any subroutine there is nothing to step out of
+		if regs.SP == 0 {
+			s.mode = runStepIn
+		}
+	}
+}
+
+func (s *Server) stop(reason string) error {
+	s.running = false
+	return s.emit("stopped", map[string]any{"reason": reason, "threadId": threadID, "allThreadsStopped": true})
+}
+
+// stopReason checks, before each instruction, whether the run is over.
+func (s *Server) stopReason() (string, bool) {
+	if s.executed == 0 {
+		// Always run the instruction we stopped at
+		return "", false
+	}
+	regs := s.vm.Registers()
+	if s.breakpoints[regs.PC] {
+		return "breakpoint", true
+	}
+	switch s.mode {
+	case runStepIn: return "step", true
+	case runStepOver: return "step", regs.PC == s.stopAddr && regs.SP == s.stopDepth
+	case runStepOut: return "step", regs.SP < s.stopDepth
+	}
+	return "", false
+}
+
+// runFrame runs instructions until the frame is finished or the run is
+// over.
+func (s *Server) runFrame() error {
+	for s.running {
+		if reason, stop := s.stopReason(); stop {
+			return s.stop(reason)
+		}
+		frameDone, end, err := s.vm.Cycle()
+		s.executed++
+		if err != nil {
+			if err := s.emit("output", map[string]any{"category": "stderr", "output": fmt.Sprintf("err: %v\n", err)}); err != nil {
+				return err
+			}
+			return s.stop("exception")
+		}
+		if end {
+			s.running, s.ended = false, true
+			if err := s.emit("exited", map[string]any{"exitCode": 0}); err != nil {
+				return err
+			}
+			return s.emit("terminated", nil)
+		}
+		if frameDone {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *Server) decode(addr uint16) common.Instruction {
+	data, err := s.vm.ReadMemory(int(addr), min(4, s.vm.MemorySize() - int(addr)))
+	if err != nil {
+		return common.Instruction{Address: addr, Name: "UNK", Size: 2}
+	}
+	return common.DecodeInstruction(data, addr)
+}
+
+// stackTrace builds frames from the PC and the return addresses on the
+// stack, naming each after the subroutine it is in.
+func (s *Server) stackTrace() []stackFrame {
+	regs := s.vm.Registers()
+	depth := min(int(regs.SP), len(regs.Stack) - 1)
+	// Frame i runs at addrs[i] inside the subroutine entered at depth - i
+	addrs := []uint16{regs.PC}
+	for level := depth; level >= 1; level-- {
+		addrs = append(addrs, regs.Stack[level] - 2)
+	}
+	frames := make([]stackFrame, len(addrs))
+	for idx, addr := range addrs {
+		name := "main"
+		if level := depth - idx; level > 0 {
+			call := common.ParseOpcode(s.decode(regs.Stack[level] - 2).Opcode)
+			name = fmt.Sprintf("sub_%03X", call.Addr)
+		}
+		frame := stackFrame{
+			ID: idx,
+			Name: fmt.Sprintf("%s (0x%03X)", name, addr),
+			InstructionPointerReference: fmt.Sprintf("0x%03X", addr),
+		}
+		if line, ok := s.listing.line(addr); ok {
+			frame.Source, frame.Line, frame.Column = s.listing.source(), line, 1
+		}
+		frames[idx] = frame
+	}
+	return frames
+}
+
+func (s *Server) variables(ref int) []variable {
+	regs := s.vm.Registers()
+	var vars []variable
+	switch ref {
+	case registersRef:
+		for idx, v := range regs.V {
+			vars = append(vars, variable{Name: fmt.Sprintf("V%X", idx), Value: fmt.Sprintf("0x%02X", v)})
+		}
+		vars = append(vars,
+			variable{Name: "I", Value: fmt.Sprintf("0x%04X", regs.I)},
+			variable{Name: "PC", Value: fmt.Sprintf("0x%04X", regs.PC)},
+			variable{Name: "SP", Value: fmt.Sprintf("%d", regs.SP)},
+		)
+	case timersRef:
+		vars = append(vars,
+			variable{Name: "DT", Value: fmt.Sprintf("%d", regs.DT)},
+			variable{Name: "ST", Value: fmt.Sprintf("%d", regs.ST)},
+		)
+	}
+	return vars
+}
+
+// listing is the disassembly that breakpoints and frames refer to, with
+// one instruction per line.
+type listing struct {
+	path string
+	lines []disasm.Line
+	// Lines with an address, in address order, starting at line 1
+	addressed []int
+	// End of the program
+	end uint16
+}
+
+// writeListing disassembles program following its control flow, so that
+// code after data is decoded from the right offset, and writes it to path.
+func writeListing(path string, program []byte) (*listing, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse file path '%s': %v", path, err)
+	}
+	lst := &listing{path: abs, lines: disasm.Analyze(program).Lines()}
+	for idx, line := range lst.lines {
+		if line.HasAddr {
+			lst.addressed = append(lst.addressed, idx + 1)
+		}
+	}
+	lst.end = common.StartAddr + uint16(len(program))
+	var b strings.Builder
+	disasm.Write(&b, lst.lines)
+	if err := os.WriteFile(abs, []byte(b.String()), 0644); err != nil {
+		return nil, fmt.Errorf("unable to write listing '%s': %v", abs, err)
+	}
+	return lst, nil
+}
+
+func (l *listing) source() *source {
+	return &source{Name: filepath.Base(l.path), Path: l.path}
+}
+
+func (l *listing) matches(path string) bool {
+	abs, err := filepath.Abs(path)
+	return err == nil && abs == l.path
+}
+
+// address returns the address of the instruction on a line. Labels and
+// data have none.
+func (l *listing) address(line int) (uint16, bool) {
+	if line < 1 || line > len(l.lines) {
+		return 0, false
+	}
+	if entry := l.lines[line - 1]; entry.HasAddr && !entry.Data {
+		return entry.Addr, true
+	}
+	return 0, false
+}
+
+// line returns the line of the instruction or data covering addr.
+func (l *listing) line(addr uint16) (int, bool) {
+	idx := sort.Search(len(l.addressed), func(i int) bool { return l.lines[l.addressed[i] - 1].Addr > addr })
+	if idx == 0 || addr >= l.end {
+		return 0, false
+	}
+	return l.addressed[idx - 1], true
+}
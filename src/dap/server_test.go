@@ -0,0 +1,425 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+// The subroutines follow 3 bytes of data, so a linear decode would misread
+// them
+var program = []byte{
+	0x60, 0x05, // 200: LD V0, 0x05   line 1
+	0x22, 0x0B, // 202: CALL 0x20B    line 2
+	0x70, 0x01, // 204: ADD V0, 0x01  line 3
+	0x12, 0x06, // 206: JP 0x206      line 5, after the label
+	0x00, 0x00, 0x00, //              line 6
+	0x71, 0x07, // 20B: ADD V1, 0x07  line 8, after the label
+	0x22, 0x11, // 20D: CALL 0x211    line 9
+	0x00, 0xEE, // 20F: RET           line 10
+	0x72, 0x01, // 211: ADD V2, 0x01  line 12, after the label
+	0x00, 0xEE, // 213: RET           line 13
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		command string
+		err     string
+	}{
+		{name: "request", input: "Content-Length: 40\r\n\r\n{\"seq\":1,\"type\":\"request\",\"command\":\"x\"}", command: "x"},
+		{name: "extra headers", input: "Content-Type: application/json\r\nContent-Length: 15\r\n\r\n{\"command\":\"y\"}", command: "y"},
+		{name: "missing length", input: "Content-Type: application/json\r\n\r\n{}", err: "invalid Content-Length header"},
+		{name: "bad length", input: "Content-Length: ten\r\n\r\n{}", err: "invalid Content-Length header"},
+		{name: "negative length", input: "Content-Length: -1\r\n\r\n{}", err: "invalid Content-Length -1"},
+		{name: "huge length", input: "Content-Length: 1073741824\r\n\r\n{}", err: "invalid Content-Length 1073741824"},
+		{name: "short body", input: "Content-Length: 10\r\n\r\n{}", err: "unexpected EOF"},
+		{name: "bad body", input: "Content-Length: 2\r\n\r\n{]", err: "invalid message"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := readRequest(bufio.NewReader(strings.NewReader(tc.input)))
+			if len(tc.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Command != tc.command {
+				t.Errorf("got command %q, want %q", req.Command, tc.command)
+			}
+		})
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	sent := []request{
+		{Seq: 1, Type: "request", Command: "launch", Arguments: json.RawMessage(`{"stopOnEntry":true}`)},
+		{Seq: 2, Type: "request", Command: "threads"},
+	}
+	go func() {
+		for _, req := range sent {
+			writeMessage(serverConn, req)
+		}
+	}()
+	r := bufio.NewReader(clientConn)
+	for _, want := range sent {
+		got, err := readRequest(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Seq != want.Seq || got.Command != want.Command || string(got.Arguments) != string(want.Arguments) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+}
+
+// message is any message from the server.
+type message struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client is the editor side of a session.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	seq  int
+	// Events received while waiting for responses
+	events []message
+}
+
+// startServer serves a VM running program, with its listing in a
+// temporary directory, and launches it stopped on entry.
+func startServer(t *testing.T) (*client, *interpreter.VirtualMachine, *listing) {
+	t.Helper()
+	vm := &interpreter.VirtualMachine{Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth}}
+	if err := vm.Init(program, 700); err != nil {
+		t.Fatal(err)
+	}
+	lst, err := writeListing(filepath.Join(t.TempDir(), "test.lst"), program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	served := make(chan error, 1)
+	go func() {
+		served <- newServer(vm, lst, serverConn).Serve()
+	}()
+	c := &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+	t.Cleanup(func() {
+		c.call("disconnect", nil)
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+		clientConn.Close()
+		serverConn.Close()
+	})
+	c.call("initialize", map[string]any{"adapterID": "emugo"})
+	c.waitEvent("initialized")
+	c.call("launch", map[string]any{"stopOnEntry": true})
+	return c, vm, lst
+}
+
+// call sends a request and returns its response, keeping the events
+// that came before it.
+func (c *client) call(command string, args any) message {
+	c.t.Helper()
+	c.seq++
+	req := map[string]any{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	if err := writeMessage(c.conn, req); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.next()
+		if msg.Type == "response" && msg.RequestSeq == c.seq {
+			if msg.Command != command {
+				c.t.Fatalf("got a response to %q, want %q", msg.Command, command)
+			}
+			return msg
+		}
+		c.events = append(c.events, msg)
+	}
+}
+
+// next reads a message from the server.
+func (c *client) next() message {
+	c.t.Helper()
+	headers, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// waitEvent returns the next event of the given name, skipping others.
+func (c *client) waitEvent(name string) message {
+	c.t.Helper()
+	for {
+		var msg message
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg.Type == "event" && msg.Event == name {
+			return msg
+		}
+	}
+}
+
+// stopped waits for the target to stop and checks the reason and PC.
+func (c *client) stopped(vm *interpreter.VirtualMachine, reason string, pc uint16) {
+	c.t.Helper()
+	msg := c.waitEvent("stopped")
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	json.Unmarshal(msg.Body, &body)
+	if body.Reason != reason {
+		c.t.Errorf("stopped for %q, want %q", body.Reason, reason)
+	}
+	if got := vm.Registers().PC; got != pc {
+		c.t.Errorf("stopped at 0x%03X, want 0x%03X", got, pc)
+	}
+}
+
+func setBreakpoints(c *client, lst *listing, lines ...int) []breakpoint {
+	c.t.Helper()
+	bps := make([]map[string]int, len(lines))
+	for idx, line := range lines {
+		bps[idx] = map[string]int{"line": line}
+	}
+	msg := c.call("setBreakpoints", map[string]any{"source": map[string]string{"path": lst.path}, "breakpoints": bps})
+	var body struct {
+		Breakpoints []breakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		c.t.Fatal(err)
+	}
+	return body.Breakpoints
+}
+
+func TestSetBreakpoints(t *testing.T) {
+	c, vm, lst := startServer(t)
+	// Label, data and out of range lines have no instruction
+	bps := setBreakpoints(c, lst, 12, 0, 4, 6, 14)
+	if len(bps) != 5 || !bps[0].Verified || bps[0].Line != 12 {
+		t.Errorf("got breakpoints %+v, want line 12 verified", bps)
+	}
+	for _, bp := range bps[1:] {
+		if bp.Verified || bp.Message != "no instruction on this line" {
+			t.Errorf("got breakpoint %+v, want no instruction on this line", bp)
+		}
+	}
+	msg := c.call("setBreakpoints", map[string]any{"source": map[string]string{"path": "other.asm"}, "breakpoints": []map[string]int{{"line": 1}}})
+	if !strings.Contains(string(msg.Body), "breakpoints can only be set in") {
+		t.Errorf("got %s for another source", msg.Body)
+	}
+	c.call("configurationDone", nil)
+	c.stopped(vm, "entry", 0x200)
+	c.call("continue", nil)
+	c.stopped(vm, "breakpoint", 0x211)
+	// Breakpoints are replaced by the next request for the listing
+	setBreakpoints(c, lst, 3)
+	c.call("continue", nil)
+	c.stopped(vm, "breakpoint", 0x204)
+	setBreakpoints(c, lst)
+	// Pausing while stopped does nothing
+	c.call("pause", nil)
+	c.call("continue", nil)
+	time.Sleep(50 * time.Millisecond)
+	c.call("pause", nil)
+	c.stopped(vm, "pause", 0x206)
+}
+
+func TestStep(t *testing.T) {
+	c, vm, _ := startServer(t)
+	c.call("configurationDone", nil)
+	c.stopped(vm, "entry", 0x200)
+	c.call("next", nil)
+	c.stopped(vm, "step", 0x202)
+	// Step over runs the whole call, including the nested one
+	c.call("next", nil)
+	c.stopped(vm, "step", 0x204)
+	if regs := vm.Registers(); regs.V[1] != 7 || regs.V[2] != 1 || regs.SP != 0 {
+		t.Errorf("next over CALL left V1=%d V2=%d SP=%d", regs.V[1], regs.V[2], regs.SP)
+	}
+	// Step out of the outermost code steps a single instruction
+	c.call("stepOut", nil)
+	c.stopped(vm, "step", 0x206)
+}
+
+func TestStepOut(t *testing.T) {
+	c, vm, lst := startServer(t)
+	setBreakpoints(c, lst, 12)
+	c.call("configurationDone", nil)
+	c.stopped(vm, "entry", 0x200)
+	c.call("continue", nil)
+	c.stopped(vm, "breakpoint", 0x211)
+	setBreakpoints(c, lst)
+	c.call("stepOut", nil)
+	c.stopped(vm, "step", 0x20F)
+	c.call("stepOut", nil)
+	c.stopped(vm, "step", 0x204)
+	c.call("stepIn", nil)
+	c.stopped(vm, "step", 0x206)
+}
+
+func TestStepOverBreakpoint(t *testing.T) {
+	c, vm, lst := startServer(t)
+	setBreakpoints(c, lst, 12)
+	c.call("configurationDone", nil)
+	c.stopped(vm, "entry", 0x200)
+	c.call("next", nil)
+	c.stopped(vm, "step", 0x202)
+	// Breakpoints inside a stepped over call still stop it
+	c.call("next", nil)
+	c.stopped(vm, "breakpoint", 0x211)
+}
+
+func TestStackTrace(t *testing.T) {
+	c, vm, lst := startServer(t)
+	setBreakpoints(c, lst, 12)
+	c.call("configurationDone", nil)
+	c.stopped(vm, "entry", 0x200)
+	c.call("continue", nil)
+	c.stopped(vm, "breakpoint", 0x211)
+	msg := c.call("stackTrace", map[string]any{"threadId": 1})
+	var body struct {
+		StackFrames []stackFrame `json:"stackFrames"`
+		TotalFrames int          `json:"totalFrames"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name string
+		line int
+	}{
+		{"sub_211 (0x211)", 12},
+		{"sub_20B (0x20D)", 9},
+		{"main (0x202)", 2},
+	}
+	if body.TotalFrames != len(want) || len(body.StackFrames) != len(want) {
+		t.Fatalf("got frames %+v, want %d", body.StackFrames, len(want))
+	}
+	for idx, frame := range body.StackFrames {
+		if frame.Name != want[idx].name || frame.Line != want[idx].line || frame.Source == nil || frame.Source.Path != lst.path {
+			t.Errorf("frame %d is %+v, want %s on line %d", idx, frame, want[idx].name, want[idx].line)
+		}
+	}
+	msg = c.call("variables", map[string]any{"variablesReference": registersRef})
+	for _, v := range []string{`{"name":"V1","value":"0x07","variablesReference":0}`, `{"name":"SP","value":"2","variablesReference":0}`} {
+		if !strings.Contains(string(msg.Body), v) {
+			t.Errorf("variables %s do not contain %s", msg.Body, v)
+		}
+	}
+}
+
+func TestExit(t *testing.T) {
+	c, vm, lst := startServer(t)
+	// 00FD exits the program
+	vm.WriteMemory(0x206, []byte{0x00, 0xFD})
+	setBreakpoints(c, lst)
+	c.call("configurationDone", nil)
+	c.stopped(vm, "entry", 0x200)
+	c.call("continue", nil)
+	c.waitEvent("exited")
+	c.waitEvent("terminated")
+	if msg := c.call("continue", nil); msg.Success || msg.Message != "the program has ended" {
+		t.Errorf("continue after the end gave %+v", msg)
+	}
+	if msg := c.call("frobnicate", nil); msg.Success || msg.Message != fmt.Sprintf("unsupported request '%s'", "frobnicate") {
+		t.Errorf("unknown request gave %+v", msg)
+	}
+}
+
+func TestReadRequestsReturnsWhenDone(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	s := newServer(nil, nil, serverConn)
+	close(s.done)
+	read := make(chan error, 1)
+	go func() { read <- s.readRequests() }()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := writeMessage(clientConn, request{Seq: 1, Type: "request", Command: "threads"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Errorf("readRequests: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readRequests blocked handing on a request after Serve returned")
+	}
+}
+
+func TestListingLines(t *testing.T) {
+	lst, err := writeListing(filepath.Join(t.TempDir(), "test.lst"), program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr uint16
+		line int
+		ok   bool
+	}{
+		{addr: 0x200, line: 1, ok: true},
+		{addr: 0x201, line: 1, ok: true},
+		{addr: 0x206, line: 5, ok: true},
+		{addr: 0x20A, line: 6, ok: true},
+		{addr: 0x20B, line: 8, ok: true},
+		{addr: 0x213, line: 13, ok: true},
+		{addr: 0x1FE, ok: false},
+		{addr: 0x215, ok: false},
+	}
+	for _, tc := range tests {
+		if line, ok := lst.line(tc.addr); ok != tc.ok || ok && line != tc.line {
+			t.Errorf("line(0x%X) = %d, %v, want %d, %v", tc.addr, line, ok, tc.line, tc.ok)
+		}
+	}
+}
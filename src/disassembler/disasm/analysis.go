@@ -0,0 +1,200 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abhinand20/emugo/common"
+)
+
+const dataBytesPerLine = 8
+
+// CodeMap is the result of following control flow through a program.
+type CodeMap struct {
+	program []byte
+	// Instructions reachable from the entry point, by program offset
+	instructions map[int]common.Instruction
+	// Program bytes covered by a reachable instruction
+	isCode []bool
+	labels map[uint16]string
+	skipTargets map[uint16]bool
+}
+
+// Analyze walks the program from its entry point, following jumps, calls
+// and both outcomes of skips. Bnnn jumps are computed at runtime, so their
+// base address is labelled but not followed.
+func Analyze(program []byte) *CodeMap {
+	cm := &CodeMap{
+		program: program,
+		instructions: make(map[int]common.Instruction),
+		isCode: make([]bool, len(program)),
+		labels: make(map[uint16]string),
+		skipTargets: make(map[uint16]bool),
+	}
+	worklist := []uint16{common.StartAddr}
+	for len(worklist) > 0 {
+		addr := worklist[len(worklist) - 1]
+		worklist = worklist[:len(worklist) - 1]
+		offset, ok := cm.offset(addr)
+		if !ok {
+			continue
+		}
+		if _, seen := cm.instructions[offset]; seen {
+			continue
+		}
+		inst := common.ParseHexInstruction(program[offset:min(offset + 4, len(program))], offset)
+		if inst.Op == common.OpUnknown || inst.Op == common.OpSYS || offset + inst.Size > len(program) {
+			continue
+		}
+		cm.instructions[offset] = inst
+		for idx := offset; idx < offset + inst.Size; idx++ {
+			cm.isCode[idx] = true
+		}
+		next := addr + uint16(inst.Size)
+		opcode := common.ParseOpcode(inst.Opcode)
+		switch inst.Op {
+		case common.OpRET, common.OpEXIT:
+		case common.OpJP:
+			cm.addLabel(opcode.Addr, "L")
+			worklist = append(worklist, opcode.Addr)
+		case common.OpCALL:
+			cm.addLabel(opcode.Addr, "sub")
+			worklist = append(worklist, opcode.Addr, next)
+		case common.OpJPV0:
+			cm.addLabel(opcode.Addr, "table")
+		case common.OpSE, common.OpSNE, common.OpSEVal, common.OpSNEVal, common.OpSKP, common.OpSKNP:
+			skipped := next + uint16(cm.sizeAt(next))
+			cm.skipTargets[skipped] = true
+			worklist = append(worklist, next, skipped)
+		case common.OpLDI:
+			cm.addLabel(opcode.Addr, "data")
+			worklist = append(worklist, next)
+		case common.OpLDILong:
+			cm.addLabel(binary.BigEndian.Uint16(program[offset + 2:]), "data")
+			worklist = append(worklist, next)
+		default:
+			worklist = append(worklist, next)
+		}
+	}
+	return cm
+}
+
+// offset converts a memory address into a program offset.
+func (cm *CodeMap) offset(addr uint16) (int, bool) {
+	offset := int(addr) - common.StartAddr
+	return offset, offset >= 0 && offset < len(cm.program)
+}
+
+// sizeAt returns the size of the instruction at addr, so that skips
+// over 4-byte long loads land on the right address.
+func (cm *CodeMap) sizeAt(addr uint16) int {
+	offset, ok := cm.offset(addr)
+	if ok && offset + 1 < len(cm.program) && binary.BigEndian.Uint16(cm.program[offset:]) == 0xF000 {
+		return 4
+	}
+	return 2
+}
+
+// addLabel names addr, keeping the first name it was given. Addresses
+// outside the program (e.g. the font) are left unlabelled.
+func (cm *CodeMap) addLabel(addr uint16, prefix string) {
+	if _, ok := cm.offset(addr); !ok {
+		return
+	}
+	if _, ok := cm.labels[addr]; !ok {
+		cm.labels[addr] = fmt.Sprintf("%s_%03X", prefix, addr)
+	}
+}
+
+// withLabel replaces the address operand of inst with its label.
+func (cm *CodeMap) withLabel(inst common.Instruction) common.Instruction {
+	def := common.Decode(inst.Opcode)
+	ops := def.Operands()
+	if len(ops) == 0 {
+		return inst
+	}
+	var target uint16
+	switch ops[len(ops) - 1] {
+	case "addr":
+		target = common.ParseOpcode(inst.Opcode).Addr
+	case "long":
+		offset, _ := cm.offset(inst.Address)
+		target = binary.BigEndian.Uint16(cm.program[offset + 2:])
+	default:
+		return inst
+	}
+	label, ok := cm.labels[target]
+	if !ok {
+		return inst
+	}
+	if len(ops) == 1 {
+		inst.LeftOp = label
+	} else {
+		inst.RightOp = label
+	}
+	return inst
+}
+
+// Lines lists labels on their own line, reachable instructions, and
+// unreached bytes as db directives.
+func (cm *CodeMap) Lines() []Line {
+	var lines []Line
+	placed := make(map[uint16]bool)
+	offset := 0
+	for offset < len(cm.program) {
+		addr := common.StartAddr + uint16(offset)
+		if label, ok := cm.labels[addr]; ok {
+			lines = append(lines, Line{Text: label + ":"})
+			placed[addr] = true
+		}
+		if inst, ok := cm.instructions[offset]; ok {
+			labelled := cm.withLabel(inst)
+			line := fmt.Sprintf("%04X: %04X %s", inst.Address, inst.Opcode, labelled.String())
+			if cm.skipTargets[addr] {
+				line = fmt.Sprintf("%-36s; skip target", line)
+			}
+			lines = append(lines, Line{Text: line, Addr: addr, HasAddr: true})
+			offset += inst.Size
+			continue
+		}
+		// Collect data up to the next instruction, label or line break
+		end := offset + 1
+		for end < len(cm.program) && end - offset < dataBytesPerLine && !cm.isCode[end] {
+			if _, ok := cm.labels[common.StartAddr + uint16(end)]; ok {
+				break
+			}
+			end++
+		}
+		var bytes []string
+		for _, b := range cm.program[offset:end] {
+			bytes = append(bytes, fmt.Sprintf("0x%02X", b))
+		}
+		lines = append(lines, Line{Text: fmt.Sprintf("%04X:      db %s", addr, strings.Join(bytes, ", ")), Addr: addr, HasAddr: true, Data: true})
+		offset = end
+	}
+	// Targets inside another instruction cannot be placed as labels
+	var unplaced []uint16
+	for addr := range cm.labels {
+		if !placed[addr] {
+			unplaced = append(unplaced, addr)
+		}
+	}
+	sort.Slice(unplaced, func(i, j int) bool { return unplaced[i] < unplaced[j] })
+	for _, addr := range unplaced {
+		lines = append(lines, Line{Text: fmt.Sprintf("%s = 0x%03X", cm.labels[addr], addr)})
+	}
+	return lines
+}
+
+// Summary lists how many bytes were found to be code and data.
+func (cm *CodeMap) Summary() string {
+	code := 0
+	for _, isCode := range cm.isCode {
+		if isCode {
+			code++
+		}
+	}
+	return fmt.Sprintf("; %d bytes of code, %d bytes of data, %d labels", code, len(cm.program) - code, len(cm.labels))
+}
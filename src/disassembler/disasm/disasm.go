@@ -0,0 +1,60 @@
+// Package disasm turns CHIP-8 ROMs into listings that the assembler
+// turns back into the exact same bytes.
+package disasm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/abhinand20/emugo/common"
+)
+
+const instructionBytes = 2
+
+// Line is a line of a listing. Label lines and the trailing definitions of
+// labels that could not be placed have no address.
+type Line struct {
+	Text string
+	// Address of the instruction or data on the line, when HasAddr is set
+	Addr uint16
+	HasAddr bool
+	// Set for db and dw lines, which hold bytes that are not run
+	Data bool
+}
+
+// Linear decodes the program linearly. Opcodes that do not decode become
+// dw directives and an odd trailing byte a db directive.
+func Linear(program []byte) []Line {
+	var lines []Line
+	idx := 0
+	end := len(program)
+	for idx < end {
+		addr := common.StartAddr + uint16(idx)
+		if idx == end - 1 {
+			lines = append(lines, Line{Text: fmt.Sprintf("%04X:      db 0x%02X", addr, program[idx]), Addr: addr, HasAddr: true, Data: true})
+			break
+		}
+		inst := common.ParseHexInstruction(program[idx:min(idx + 4, end)], idx)
+		line := Line{Addr: addr, HasAddr: true}
+		if inst.Op == common.OpUnknown {
+			inst.Name = "dw"
+			inst.LeftOp = fmt.Sprintf("0x%04X", inst.Opcode)
+			inst.Size = instructionBytes
+			line.Data = true
+		}
+		line.Text = inst.Line()
+		lines = append(lines, line)
+		idx += inst.Size
+	}
+	return lines
+}
+
+// Write writes the lines of a listing.
+func Write(w io.Writer, lines []Line) error {
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
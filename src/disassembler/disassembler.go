@@ -1,22 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 
-	"encoding/binary"
-
+	"github.com/abhinand20/emugo/assembler/asm"
 	"github.com/abhinand20/emugo/common"
+	"github.com/abhinand20/emugo/disassembler/disasm"
 )
 
 var InputFile string
-const (
-	instructionBytes = 2
-)
-
+var Recursive bool
+var Verify bool
 
 func initFlags() {
 	flag.StringVar(&InputFile, "file", "", "File containing CHIP-8 hex code.")
+	flag.BoolVar(&Recursive, "recursive", false, "Follow control flow from the entry point, listing unreached bytes as data.")
+	flag.BoolVar(&Verify, "verify", false, "Reassemble the listing and check it reproduces the input byte for byte.")
 }
 
 func validateFlags() error {
@@ -26,27 +29,34 @@ func validateFlags() error {
 	return nil
 }
 
-func parseHexInstructions(arr []byte) []common.Instruction {
-	var instructions []common.Instruction
-	idx := 0
-	end := len(arr)
-	for idx < end {
-		var inst common.Instruction
-		// Not a valid instruction
-		if idx == end - 1 {
-			inst.Name = "UNK"
-			inst.Address = common.StartAddr + uint16(idx)
-			inst.Opcode = binary.BigEndian.Uint16([]byte{arr[idx], 0})
-		} else {
-			opcode := make([]byte, 2)
-			opcode[0] = arr[idx]
-			opcode[1] = arr[idx+1]
-			inst = common.ParseHexInstruction(opcode, idx)
+// writeListing writes a listing of the program that the assembler
+// turns back into the exact same bytes.
+func writeListing(w io.Writer, content []byte, recursive bool) {
+	fmt.Fprintf(w, "; %d bytes\n", len(content))
+	if recursive {
+		cm := disasm.Analyze(content)
+		disasm.Write(w, cm.Lines())
+		fmt.Fprintln(w, cm.Summary())
+		return
+	}
+	disasm.Write(w, disasm.Linear(content))
+}
+
+// verifyListing reassembles the listing and compares it with the program.
+func verifyListing(content []byte, listing string) error {
+	program, err := asm.AssembleSource(InputFile, listing)
+	if err != nil {
+		return fmt.Errorf("listing does not assemble: %v", err)
+	}
+	if bytes.Equal(program.Bytes, content) {
+		return nil
+	}
+	for idx := 0; idx < min(len(content), len(program.Bytes)); idx++ {
+		if content[idx] != program.Bytes[idx] {
+			return fmt.Errorf("byte at %04X reassembles to %02X instead of %02X", common.StartAddr + idx, program.Bytes[idx], content[idx])
 		}
-		instructions = append(instructions, inst)
-		idx += 2
 	}
-	return instructions
+	return fmt.Errorf("listing reassembles to %d bytes instead of %d", len(program.Bytes), len(content))
 }
 
 func main() {
@@ -61,8 +71,15 @@ func main() {
 		fmt.Printf("err: %v\n", err)
 		return
 	}
-	instructs := parseHexInstructions(content)
-	for _, i := range instructs {
-		i.Print()
+	var listing bytes.Buffer
+	writeListing(&listing, content, Recursive)
+	if Verify {
+		if err := verifyListing(content, listing.String()); err != nil {
+			fmt.Printf("err: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Verified: listing reassembles to the %d input bytes\n", len(content))
+		return
 	}
-}
\ No newline at end of file
+	fmt.Print(listing.String())
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/abhinand20/emugo/common"
+)
+
+func roundTrip(t *testing.T, content []byte) {
+	t.Helper()
+	for _, recursive := range []bool{false, true} {
+		var listing bytes.Buffer
+		writeListing(&listing, content, recursive)
+		if err := verifyListing(content, listing.String()); err != nil {
+			t.Errorf("recursive=%v: %v\n%s", recursive, err, listing.String())
+		}
+	}
+}
+
+func TestListingRoundTripsROMs(t *testing.T) {
+	roms, err := filepath.Glob("../../roms/*.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, err := filepath.Glob("../../roms/tests/*.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rom := range append(roms, tests...) {
+		t.Run(filepath.Base(rom), func(t *testing.T) {
+			content, err := common.ReadFile(rom)
+			if err != nil {
+				t.Fatal(err)
+			}
+			roundTrip(t, content)
+		})
+	}
+}
+
+func TestListingRoundTripsEdgeCases(t *testing.T) {
+	for name, content := range map[string][]byte{
+		"unknown opcodes":       {0x00, 0x00, 0x5A, 0xB7, 0xE1, 0x23},
+		"odd trailing byte":     {0x60, 0x01, 0xAB},
+		"truncated long load":   {0x60, 0x01, 0xF0, 0x00},
+		"long load":             {0xF0, 0x00, 0x12, 0x34, 0x12, 0x00},
+		"jump into instruction": {0xA2, 0x04, 0x12, 0x03, 0x00, 0xEE},
+	} {
+		t.Run(name, func(t *testing.T) {
+			roundTrip(t, content)
+		})
+	}
+}
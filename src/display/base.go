@@ -1,5 +1,12 @@
 package display
 
+const (
+	LoresWidth = 64
+	LoresHeight = 32
+	HiresWidth = 128
+	HiresHeight = 64
+)
+
 // A display interface that can be implemented
 // using any display library under the hood
 type Display interface {
@@ -7,8 +14,26 @@ type Display interface {
 	Init()
 	Clear()
 	// Update the internal states to setup rendering
-	// based on the draw instruction operands
-	UpdateState(*[4096]byte, uint16, byte, byte, byte) bool
-	// Reneder called for each display instruction execution
+	// based on the draw instruction operands. The last argument
+	// selects clipping instead of wrapping at the screen edges.
+	// A height of 0 draws a 16x16 sprite. Sprite data is read from
+	// memory starting at the given address, wrapping around its end.
+	UpdateState([]byte, uint16, byte, byte, byte, bool) bool
+	// Render called once per frame when the display has changed
 	Render()
+	// Switch resolution at runtime, clears the display
+	SetResolution(width, height uint32)
+	Resolution() (uint32, uint32)
+	// Plane bits of the pixel at column x, row y
+	Pixel(x, y uint32) uint8
+	// Replace the plane bits of a pixel, to restore a saved display
+	SetPixel(x, y uint32, planes uint8)
+	// Select the XO-CHIP bit-planes affected by drawing, clearing
+	// and scrolling.
+	SetPlanes(mask uint8)
+	// Scroll the display contents by n pixels
+	ScrollUp(n uint32)
+	ScrollDown(n uint32)
+	ScrollLeft(n uint32)
+	ScrollRight(n uint32)
 }
\ No newline at end of file
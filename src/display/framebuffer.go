@@ -0,0 +1,187 @@
+package display
+
+import (
+	"strings"
+)
+
+// Characters used for each combination of the 2 planes
+var planeChars = [4]rune{' ', '\u2588', '\u2592', '\u2593'}
+
+// Framebuffer implements the Display interface without any output,
+// it is used as is for headless runs and embedded by other displays.
+type Framebuffer struct {
+	// Each cell holds one bit per XO-CHIP plane
+	Grid [][]uint8
+	Height uint32
+	Width uint32
+	planes uint8
+}
+
+func (f *Framebuffer) Init() {
+	f.planes = 1
+	f.allocGrid()
+}
+
+func (f *Framebuffer) Clear() {
+	for i := range f.Grid {
+		for j := range f.Grid[i] {
+			f.Grid[i][j] &^= f.planes
+		}
+	}
+}
+
+func (f *Framebuffer) SetPlanes(mask uint8) {
+	f.planes = mask & 0x3
+}
+
+func (f *Framebuffer) UpdateState(memory []byte, i uint16, vx, vy, n byte, clip bool) bool {
+	// SCHIP Dxy0 draws a 16x16 sprite made of 2 bytes per row
+	var width, height uint32 = 8, uint32(n)
+	if n == 0 {
+		width, height = 16, 16
+	}
+	addr := int(i)
+	collision := false
+	// Each selected plane consumes its own copy of the sprite data
+	for plane := uint8(1); plane <= 2; plane <<= 1 {
+		if f.planes & plane == 0 {
+			continue
+		}
+		if f.drawPlane(memory, addr, vx, vy, width, height, plane, clip) {
+			collision = true
+		}
+		addr += int(width / 8 * height)
+	}
+	return collision
+}
+
+func (f *Framebuffer) drawPlane(memory []byte, addr int, vx, vy byte, width, height uint32, plane uint8, clip bool) bool {
+	collision := false
+	bytesPerRow := int(width / 8)
+	// The starting position always wraps, only the sprite body is clipped
+	startRow := uint32(vy) % f.Height
+	startCol := uint32(vx) % f.Width
+	for ib := uint32(0); ib < height; ib++ {
+		var spriteRow uint32
+		for b := 0; b < bytesPerRow; b++ {
+			spriteRow = spriteRow << 8 | uint32(memory[(addr + int(ib) * bytesPerRow + b) % len(memory)])
+		}
+		row := startRow + ib
+		if row >= f.Height {
+			if clip {
+				break
+			}
+			row %= f.Height
+		}
+		// XOR with all pixels in this row
+		for idx := uint32(0); idx < width; idx++ {
+			if (spriteRow >> (width - 1 - idx)) & 0x1 == 0 {
+				continue
+			}
+			col := startCol + idx
+			if col >= f.Width {
+				if clip {
+					break
+				}
+				col %= f.Width
+			}
+			if f.Grid[row][col] & plane != 0 {
+				collision = true
+			}
+			f.Grid[row][col] ^= plane
+		}
+	}
+	return collision
+}
+
+// Render is a no-op, headless runs read the Grid directly.
+func (f *Framebuffer) Render() {}
+
+// String draws the grid as text surrounded by a border.
+func (f *Framebuffer) String() string {
+	var sb strings.Builder
+	f.drawHorizontalBorder(&sb)
+	for i := range f.Grid {
+		sb.WriteRune('|')
+		for j := range f.Grid[i] {
+			sb.WriteRune(planeChars[f.Grid[i][j] & 0x3])
+		}
+		sb.WriteString("|\n")
+	}
+	f.drawHorizontalBorder(&sb)
+	return sb.String()
+}
+
+func (f *Framebuffer) drawHorizontalBorder(sb *strings.Builder) {
+	sb.WriteRune('+')
+	sb.WriteString(strings.Repeat("-", int(f.Width)))
+	sb.WriteString("+\n")
+}
+
+func (f *Framebuffer) SetResolution(width, height uint32) {
+	f.Width = width
+	f.Height = height
+	f.allocGrid()
+}
+
+func (f *Framebuffer) Resolution() (uint32, uint32) {
+	return f.Width, f.Height
+}
+
+func (f *Framebuffer) Pixel(x, y uint32) uint8 {
+	if y >= f.Height || x >= f.Width {
+		return 0
+	}
+	return f.Grid[y][x]
+}
+
+func (f *Framebuffer) SetPixel(x, y uint32, planes uint8) {
+	if y >= f.Height || x >= f.Width {
+		return
+	}
+	f.Grid[y][x] = planes & 0x3
+}
+
+func (f *Framebuffer) ScrollUp(n uint32) {
+	f.scroll(0, -int(n))
+}
+
+func (f *Framebuffer) ScrollDown(n uint32) {
+	f.scroll(0, int(n))
+}
+
+func (f *Framebuffer) ScrollLeft(n uint32) {
+	f.scroll(-int(n), 0)
+}
+
+func (f *Framebuffer) ScrollRight(n uint32) {
+	f.scroll(int(n), 0)
+}
+
+// scroll moves the selected planes by (dx, dy), filling the
+// uncovered area with blank pixels.
+func (f *Framebuffer) scroll(dx, dy int) {
+	h, w := int(f.Height), int(f.Width)
+	shifted := make([][]uint8, h)
+	for r := range shifted {
+		shifted[r] = make([]uint8, w)
+		for c := range shifted[r] {
+			srcRow, srcCol := r - dy, c - dx
+			if srcRow >= 0 && srcRow < h && srcCol >= 0 && srcCol < w {
+				shifted[r][c] = f.Grid[srcRow][srcCol] & f.planes
+			}
+		}
+	}
+	for r := range f.Grid {
+		for c := range f.Grid[r] {
+			f.Grid[r][c] = f.Grid[r][c] &^ f.planes | shifted[r][c]
+		}
+	}
+}
+
+func (f *Framebuffer) allocGrid() {
+	f.Grid = make([][]uint8, f.Height)
+	for i := range f.Grid {
+		f.Grid[i] = make([]uint8, f.Width)
+	}
+}
\ No newline at end of file
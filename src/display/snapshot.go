@@ -0,0 +1,110 @@
+package display
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Palette maps each combination of the 2 planes to a colour.
+type Palette [4]color.RGBA
+
+var DefaultPalette = Palette{
+	{0x00, 0x00, 0x00, 0xFF},
+	{0xFF, 0xFF, 0xFF, 0xFF},
+	{0xAA, 0xAA, 0xAA, 0xFF},
+	{0x55, 0x55, 0x55, 0xFF},
+}
+
+// ParsePalette reads up to 4 comma separated RRGGBB colours,
+// entries left out keep their default colour.
+func ParsePalette(s string) (Palette, error) {
+	palette := DefaultPalette
+	if len(s) == 0 {
+		return palette, nil
+	}
+	entries := strings.Split(s, ",")
+	if len(entries) > len(palette) {
+		return palette, fmt.Errorf("palette has %d colours, at most %d allowed", len(entries), len(palette))
+	}
+	for idx, entry := range entries {
+		entry = strings.TrimPrefix(strings.TrimSpace(entry), "#")
+		rgb, err := strconv.ParseUint(entry, 16, 32)
+		if err != nil || len(entry) != 6 {
+			return palette, fmt.Errorf("invalid palette colour '%s'", entry)
+		}
+		palette[idx] = color.RGBA{byte(rgb >> 16), byte(rgb >> 8), byte(rgb), 0xFF}
+	}
+	return palette, nil
+}
+
+// Snapshot renders the display into an image, scaling every pixel
+// into a scale x scale square.
+func Snapshot(d Display, scale int, palette Palette) *image.Paletted {
+	scale = max(1, scale)
+	width, height := d.Resolution()
+	colours := make(color.Palette, len(palette))
+	for idx := range palette {
+		colours[idx] = palette[idx]
+	}
+	img := image.NewPaletted(image.Rect(0, 0, int(width) * scale, int(height) * scale), colours)
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			img.SetColorIndex(x, y, d.Pixel(uint32(x / scale), uint32(y / scale)) & 0x3)
+		}
+	}
+	return img
+}
+
+func WritePNG(w io.Writer, d Display, scale int, palette Palette) error {
+	return png.Encode(w, Snapshot(d, scale, palette))
+}
+
+// WritePBM writes a plain netpbm bitmap, a pixel is set
+// when it is lit on any plane.
+func WritePBM(w io.Writer, d Display, scale int) error {
+	scale = max(1, scale)
+	width, height := d.Resolution()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P1\n%d %d\n", int(width) * scale, int(height) * scale)
+	for y := 0; y < int(height) * scale; y++ {
+		for x := 0; x < int(width) * scale; x++ {
+			bit := '0'
+			if d.Pixel(uint32(x / scale), uint32(y / scale)) != 0 {
+				bit = '1'
+			}
+			if x > 0 {
+				bw.WriteByte(' ')
+			}
+			bw.WriteRune(bit)
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// SaveSnapshot writes the display to path, as a PBM when the
+// extension is .pbm and as a PNG otherwise.
+func SaveSnapshot(d Display, path string, scale int, palette Palette) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot '%s': %v", path, err)
+	}
+	defer f.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".pbm" {
+		err = WritePBM(f, d, scale)
+	} else {
+		err = WritePNG(f, d, scale, palette)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write snapshot '%s': %v", path, err)
+	}
+	return nil
+}
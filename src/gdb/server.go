@@ -0,0 +1,483 @@
+// Package gdb serves a VirtualMachine over the GDB Remote Serial Protocol,
+// so that existing debugger frontends can attach to it.
+package gdb
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+// Registers in the order of the g packet, with their size in bytes.
+// Multi-byte registers are big-endian, like CHIP-8 memory.
+var registers = []struct {
+	name string
+	size int
+}{
+	{"v0", 1}, {"v1", 1}, {"v2", 1}, {"v3", 1},
+	{"v4", 1}, {"v5", 1}, {"v6", 1}, {"v7", 1},
+	{"v8", 1}, {"v9", 1}, {"va", 1}, {"vb", 1},
+	{"vc", 1}, {"vd", 1}, {"ve", 1}, {"vf", 1},
+	{"i", 2}, {"pc", 2}, {"sp", 2}, {"dt", 1}, {"st", 1},
+}
+
+const (
+	regI = 16 + iota
+	regPC
+	regSP
+	regDT
+	regST
+)
+
+const (
+	frameRate = 60
+	// Byte sent by the client to interrupt a running target
+	interrupt = 0x03
+	maxPacketSize = 0x4000
+)
+
+// Stop replies
+const (
+	sigInt = "S02"
+	sigTrap = "S05"
+	sigIll = "S04"
+	exited = "W00"
+)
+
+// Server runs one debugging session over a connection.
+type Server struct {
+	vm *interpreter.VirtualMachine
+	conn io.ReadWriter
+	breakpoints map[uint16]bool
+	packets chan string
+	interrupts chan struct{}
+	// Closed when Serve returns, so that the reader stops handing it packets
+	done chan struct{}
+	// Why the target last stopped, as a stop reply
+	stopReply string
+}
+
+// ListenAndServe waits for a client on addr, such as ":1234", and serves
+// it until it detaches or kills the target.
+func ListenAndServe(vm *interpreter.VirtualMachine, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on '%s': %v", addr, err)
+	}
+	defer listener.Close()
+	fmt.Printf("Waiting for GDB on %s\n", listener.Addr())
+	conn, err := listener.Accept()
+	if err != nil {
+		return fmt.Errorf("unable to accept connection: %v", err)
+	}
+	defer conn.Close()
+	return NewServer(vm, conn).Serve()
+}
+
+// NewServer creates a session for vm, which must be initialised.
+func NewServer(vm *interpreter.VirtualMachine, conn io.ReadWriter) *Server {
+	return &Server{
+		vm: vm,
+		conn: conn,
+		breakpoints: make(map[uint16]bool),
+		packets: make(chan string),
+		interrupts: make(chan struct{}, 1),
+		done: make(chan struct{}),
+		stopReply: sigTrap,
+	}
+}
+
+// Serve handles packets until the client detaches, kills the target or
+// disconnects. The target starts stopped at its entry point.
+func (s *Server) Serve() error {
+	if s.vm.Input != nil {
+		s.vm.Input.Start()
+		defer s.vm.Input.Stop()
+	}
+	defer close(s.done)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.readPackets()
+	}()
+	for {
+		var packet string
+		select {
+		case packet = <-s.packets:
+		case <-s.interrupts:
+			// Already stopped
+			continue
+		case err := <-readErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		reply, done, err := s.handle(packet)
+		if err != nil {
+			return err
+		}
+		if err := s.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// readPackets splits the input into packets and interrupts, checking
+// and acknowledging each packet until the client switches to no ack
+// mode. It returns nil once Serve is done.
+func (s *Server) readPackets() error {
+	r := bufio.NewReader(s.conn)
+	// Only this goroutine acknowledges, so it tracks the mode itself
+	noAck := false
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case interrupt:
+			select {
+			case s.interrupts <- struct{}{}:
+			default:
+			}
+			continue
+		case '$':
+		default:
+			// Acks and noise between packets
+			continue
+		}
+		data, err := r.ReadString('#')
+		if err != nil {
+			return err
+		}
+		data = data[:len(data) - 1]
+		sum := make([]byte, 2)
+		if _, err := io.ReadFull(r, sum); err != nil {
+			return err
+		}
+		if !noAck {
+			expected, err := strconv.ParseUint(string(sum), 16, 8)
+			if err != nil || byte(expected) != checksum(data) {
+				s.conn.Write([]byte("-"))
+				continue
+			}
+			s.conn.Write([]byte("+"))
+			// The request itself is still acknowledged
+			noAck = data == "QStartNoAckMode"
+		}
+		select {
+		case s.packets <- data:
+		case <-s.done:
+			return nil
+		}
+	}
+}
+
+func (s *Server) send(data string) error {
+	_, err := fmt.Fprintf(s.conn, "$%s#%02x", data, checksum(data))
+	return err
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for idx := 0; idx < len(data); idx++ {
+		sum += data[idx]
+	}
+	return sum
+}
+
+// handle runs a packet and returns its reply, and whether the session
+// is over.
+func (s *Server) handle(packet string) (string, bool, error) {
+	if len(packet) == 0 {
+		return "", false, nil
+	}
+	args := packet[1:]
+	switch packet[0] {
+	case '?': return s.stopReply, false, nil
+	case 'g': return s.readRegisters(), false, nil
+	case 'G': return s.writeRegisters(args), false, nil
+	case 'p': return s.readRegister(args), false, nil
+	case 'P': return s.writeRegister(args), false, nil
+	case 'm': return s.readMemory(args), false, nil
+	case 'M': return s.writeMemory(args), false, nil
+	case 'c': return s.resume(args, false), false, nil
+	case 's': return s.resume(args, true), false, nil
+	case 'Z', 'z': return s.breakpoint(packet[0] == 'Z', args), false, nil
+	case 'H': return "OK", false, nil
+	case 'T': return "OK", false, nil
+	case 'D': return "OK", true, nil
+	case 'k': return "", true, nil
+	case 'q', 'Q': return s.query(packet), false, nil
+	}
+	// Empty replies mark unsupported packets
+	return "", false, nil
+}
+
+func (s *Server) query(packet string) string {
+	name, args, _ := strings.Cut(packet, ":")
+	switch name {
+	case "qSupported":
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+", maxPacketSize)
+	case "QStartNoAckMode": return "OK"
+	case "qAttached": return "1"
+	case "qC": return "QC1"
+	case "qfThreadInfo": return "m1"
+	case "qsThreadInfo": return "l"
+	case "qXfer":
+		return s.features(args)
+	}
+	return ""
+}
+
+// features serves the target description naming the registers.
+func (s *Server) features(args string) string {
+	parts := strings.Split(args, ":")
+	if len(parts) != 4 || parts[0] != "features" || parts[1] != "read" || parts[2] != "target.xml" {
+		return "E00"
+	}
+	var offset, length uint64
+	if _, err := fmt.Sscanf(parts[3], "%x,%x", &offset, &length); err != nil {
+		return "E00"
+	}
+	doc := targetDescription()
+	if offset >= uint64(len(doc)) {
+		return "l"
+	}
+	end := min(offset + length, uint64(len(doc)))
+	if end == uint64(len(doc)) {
+		return "l" + doc[offset:end]
+	}
+	return "m" + doc[offset:end]
+}
+
+func targetDescription() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><!DOCTYPE target SYSTEM "gdb-target.dtd"><target><feature name="org.emugo.chip8">`)
+	for idx, reg := range registers {
+		regType := "uint8"
+		switch idx {
+		case regPC: regType = "code_ptr"
+		case regI: regType = "data_ptr"
+		default:
+			if reg.size == 2 {
+				regType = "uint16"
+			}
+		}
+		fmt.Fprintf(&b, `<reg name="%s" bitsize="%d" regnum="%d" type="%s"/>`, reg.name, reg.size * 8, idx, regType)
+	}
+	b.WriteString(`</feature></target>`)
+	return b.String()
+}
+
+func registerValue(regs interpreter.Registers, idx int) int {
+	switch idx {
+	case regI: return int(regs.I)
+	case regPC: return int(regs.PC)
+	case regSP: return int(regs.SP)
+	case regDT: return int(regs.DT)
+	case regST: return int(regs.ST)
+	}
+	return int(regs.V[idx])
+}
+
+func setRegisterValue(regs *interpreter.Registers, idx, value int) {
+	switch idx {
+	case regI: regs.I = uint16(value)
+	case regPC: regs.PC = uint16(value)
+	case regSP: regs.SP = uint16(value)
+	case regDT: regs.DT = uint8(value)
+	case regST: regs.ST = uint8(value)
+	default: regs.V[idx] = uint8(value)
+	}
+}
+
+func formatRegister(value, size int) string {
+	return fmt.Sprintf("%0*x", size * 2, value)
+}
+
+func (s *Server) readRegisters() string {
+	regs := s.vm.Registers()
+	var b strings.Builder
+	for idx, reg := range registers {
+		b.WriteString(formatRegister(registerValue(regs, idx), reg.size))
+	}
+	return b.String()
+}
+
+func (s *Server) writeRegisters(args string) string {
+	regs := s.vm.Registers()
+	for idx, reg := range registers {
+		if len(args) < reg.size * 2 {
+			return "E01"
+		}
+		value, err := strconv.ParseUint(args[:reg.size * 2], 16, 16)
+		if err != nil {
+			return "E01"
+		}
+		setRegisterValue(&regs, idx, int(value))
+		args = args[reg.size * 2:]
+	}
+	if err := s.vm.SetRegisters(regs); err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+func (s *Server) readRegister(args string) string {
+	idx, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || int(idx) >= len(registers) {
+		return "E01"
+	}
+	return formatRegister(registerValue(s.vm.Registers(), int(idx)), registers[idx].size)
+}
+
+func (s *Server) writeRegister(args string) string {
+	num, hexValue, found := strings.Cut(args, "=")
+	idx, err := strconv.ParseUint(num, 16, 8)
+	if !found || err != nil || int(idx) >= len(registers) || len(hexValue) != registers[idx].size * 2 {
+		return "E01"
+	}
+	value, err := strconv.ParseUint(hexValue, 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	regs := s.vm.Registers()
+	setRegisterValue(&regs, int(idx), int(value))
+	if err := s.vm.SetRegisters(regs); err != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// parseRange reads the "addr,length" arguments of memory packets.
+func parseRange(args string) (int, int, error) {
+	addr, length, found := strings.Cut(args, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("missing length")
+	}
+	a, err := strconv.ParseUint(addr, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(length, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(a), int(n), nil
+}
+
+func (s *Server) readMemory(args string) string {
+	addr, n, err := parseRange(args)
+	if err != nil {
+		return "E01"
+	}
+	if addr >= s.vm.MemorySize() {
+		return "E14"
+	}
+	// Reads running past the end return what is available
+	n = min(n, s.vm.MemorySize() - addr, maxPacketSize / 2)
+	data, err := s.vm.ReadMemory(addr, n)
+	if err != nil {
+		return "E14"
+	}
+	return hex.EncodeToString(data)
+}
+
+func (s *Server) writeMemory(args string) string {
+	rng, hexData, found := strings.Cut(args, ":")
+	if !found {
+		return "E01"
+	}
+	addr, n, err := parseRange(rng)
+	if err != nil {
+		return "E01"
+	}
+	data, err := hex.DecodeString(hexData)
+	if err != nil || len(data) != n {
+		return "E01"
+	}
+	if err := s.vm.WriteMemory(addr, data); err != nil {
+		return "E14"
+	}
+	return "OK"
+}
+
+// breakpoint handles Z and z packets for software and hardware
+// execution breakpoints, which are the same for the VM.
+func (s *Server) breakpoint(insert bool, args string) string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 2 || (fields[0] != "0" && fields[0] != "1") {
+		return ""
+	}
+	addr, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	if insert {
+		s.breakpoints[uint16(addr)] = true
+	} else {
+		delete(s.breakpoints, uint16(addr))
+	}
+	return "OK"
+}
+
+// resume runs the target, optionally from a new address, for a single
+// instruction or until a breakpoint, interrupt or the end of the program.
+// Running frames are paced at 60Hz like the VM's own Run.
+func (s *Server) resume(args string, step bool) string {
+	if len(args) > 0 {
+		addr, err := strconv.ParseUint(args, 16, 16)
+		if err != nil {
+			return "E01"
+		}
+		regs := s.vm.Registers()
+		regs.PC = uint16(addr)
+		s.vm.SetRegisters(regs)
+	}
+	// Drop interrupts sent while stopped
+	select {
+	case <-s.interrupts:
+	default:
+	}
+	frameClk := time.NewTicker(time.Second / frameRate)
+	defer frameClk.Stop()
+	for first := true; ; first = false {
+		if !first && s.breakpoints[s.vm.Registers().PC] {
+			s.stopReply = sigTrap
+			return s.stopReply
+		}
+		frameDone, end, err := s.vm.Cycle()
+		switch {
+		case err != nil:
+			fmt.Printf("err: %v\n", err)
+			s.stopReply = sigIll
+		case end:
+			s.stopReply = exited
+		case step:
+			s.stopReply = sigTrap
+		}
+		if err != nil || end || step {
+			return s.stopReply
+		}
+		if !frameDone {
+			continue
+		}
+		select {
+		case <-s.interrupts:
+			s.stopReply = sigInt
+			return s.stopReply
+		case <-frameClk.C:
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package gdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+var program = []byte{
+	0x60, 0x05, // 200: LD V0, 0x05
+	0x22, 0x0A, // 202: CALL 0x20A
+	0x70, 0x01, // 204: ADD V0, 0x01
+	0x12, 0x06, // 206: JP 0x206
+	0x00, 0x00,
+	0x71, 0x07, // 20A: ADD V1, 0x07
+	0x00, 0xEE, // 20C: RET
+}
+
+// client is the GDB side of a session.
+type client struct {
+	t     *testing.T
+	conn  net.Conn
+	r     *bufio.Reader
+	noAck bool
+}
+
+// startServer serves a VM running program over a pipe, returning the
+// client end and the result of Serve.
+func startServer(t *testing.T, program []byte) (*client, *interpreter.VirtualMachine, chan error) {
+	t.Helper()
+	vm := &interpreter.VirtualMachine{Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth}}
+	if err := vm.Init(program, 700); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	served := make(chan error, 1)
+	go func() {
+		served <- NewServer(vm, serverConn).Serve()
+	}()
+	t.Cleanup(func() { clientConn.Close() })
+	return &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}, vm, served
+}
+
+func (c *client) write(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) send(data string) {
+	c.t.Helper()
+	c.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+}
+
+func (c *client) readByte() byte {
+	c.t.Helper()
+	b, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+func (c *client) expectAck(want byte) {
+	c.t.Helper()
+	if c.noAck {
+		return
+	}
+	if b := c.readByte(); b != want {
+		c.t.Fatalf("got ack %q, want %q", b, want)
+	}
+}
+
+// reply reads a packet from the server, checking its checksum.
+func (c *client) reply() string {
+	c.t.Helper()
+	if b := c.readByte(); b != '$' {
+		c.t.Fatalf("got %q, want the start of a packet", b)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	data = data[:len(data)-1]
+	sum := []byte{c.readByte(), c.readByte()}
+	if want := fmt.Sprintf("%02x", checksum(data)); string(sum) != want {
+		c.t.Fatalf("reply %q has checksum %s, want %s", data, sum, want)
+	}
+	if !c.noAck {
+		c.write("+")
+	}
+	return data
+}
+
+// call sends a packet and returns the reply.
+func (c *client) call(data string) string {
+	c.t.Helper()
+	c.send(data)
+	c.expectAck('+')
+	return c.reply()
+}
+
+func (c *client) check(data, want string) {
+	c.t.Helper()
+	if got := c.call(data); got != want {
+		c.t.Errorf("%s: got %q, want %q", data, got, want)
+	}
+}
+
+func TestFraming(t *testing.T) {
+	c, _, served := startServer(t, program)
+	// Acks and noise between packets are skipped
+	c.write("+++\n")
+	c.check("?", "S05")
+	c.write("$?#00")
+	c.expectAck('-')
+	// Checksums are lowercase or uppercase hex
+	c.write(fmt.Sprintf("$m200,2#%02X", checksum("m200,2")))
+	c.expectAck('+')
+	if got := c.reply(); got != "6005" {
+		t.Errorf("got %q, want 6005", got)
+	}
+	c.check("qC", "QC1")
+	// Unsupported packets have empty replies
+	c.check("vMustReplyEmpty", "")
+	c.check("D", "OK")
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestNoAckMode(t *testing.T) {
+	c, _, _ := startServer(t, program)
+	if got := c.call("qSupported:multiprocess+"); !strings.Contains(got, "QStartNoAckMode+") {
+		t.Errorf("qSupported = %q, want QStartNoAckMode+", got)
+	}
+	c.check("QStartNoAckMode", "OK")
+	c.noAck = true
+	// Checksums are no longer checked either
+	c.write("$?#00")
+	if got := c.reply(); got != "S05" {
+		t.Errorf("got %q, want S05", got)
+	}
+	c.check("p11", "0200")
+}
+
+func TestRegisters(t *testing.T) {
+	c, vm, _ := startServer(t, program)
+	regs := vm.Registers()
+	regs.V[0], regs.V[0xF], regs.I, regs.DT, regs.ST = 0x12, 0x01, 0x0ABC, 0x30, 0x04
+	vm.SetRegisters(regs)
+	c.check("g", "12000000000000000000000000000001"+"0abc"+"0200"+"0000"+"30"+"04")
+	c.check("p0", "12")
+	c.check("pf", "01")
+	c.check("p10", "0abc")
+	c.check("p11", "0200")
+	c.check("p13", "30")
+	c.check("p15", "E01")
+	c.check("P1=7f", "OK")
+	c.check("P10=0300", "OK")
+	c.check("P14=09", "OK")
+	c.check("P1=7", "E01")
+	c.check("Pzz=00", "E01")
+	regs = vm.Registers()
+	if regs.V[1] != 0x7F || regs.I != 0x300 || regs.ST != 9 {
+		t.Errorf("P left V1=0x%02X I=0x%03X ST=%d", regs.V[1], regs.I, regs.ST)
+	}
+	all := "0102030405060708090a0b0c0d0e0f10" + "0400" + "0208" + "0001" + "05" + "06"
+	c.check("G"+all, "OK")
+	c.check("g", all)
+	regs = vm.Registers()
+	if regs.V[0] != 1 || regs.V[0xF] != 0x10 || regs.I != 0x400 || regs.PC != 0x208 || regs.SP != 1 || regs.DT != 5 || regs.ST != 6 {
+		t.Errorf("G left %+v", regs)
+	}
+	c.check("G0102", "E01")
+	// The stack pointer is limited to the stack
+	c.check("G"+strings.Replace(all, "0001", "0010", 1), "E01")
+}
+
+func TestMemory(t *testing.T) {
+	c, vm, _ := startServer(t, program)
+	c.check("m200,4", "6005220a")
+	c.check("M300,3:a1b2c3", "OK")
+	c.check("m2ff,5", "00a1b2c300")
+	if data, _ := vm.ReadMemory(0x300, 3); string(data) != "\xa1\xb2\xc3" {
+		t.Errorf("M wrote % X", data)
+	}
+	// Reads past the end return what is available
+	c.check("mffe,10", "0000")
+	c.check("m1000,1", "E14")
+	c.check("Mffe,4:01020304", "E14")
+	c.check("M300,2:a1", "E01")
+	c.check("M300,1:zz", "E01")
+	c.check("m300", "E01")
+}
+
+func TestBreakpoints(t *testing.T) {
+	c, vm, _ := startServer(t, program)
+	c.check("Z0,20a,2", "OK")
+	c.check("Z1,204,2", "OK")
+	c.check("Z2,300,1", "")
+	c.check("c", "S05")
+	if pc := vm.Registers().PC; pc != 0x20A {
+		t.Errorf("stopped at 0x%03X, want 0x20A", pc)
+	}
+	c.check("c", "S05")
+	if pc := vm.Registers().PC; pc != 0x204 {
+		t.Errorf("stopped at 0x%03X, want 0x204", pc)
+	}
+	c.check("z0,20a,2", "OK")
+	c.check("c200", "S05")
+	if pc := vm.Registers().PC; pc != 0x204 {
+		t.Errorf("stopped at 0x%03X, want 0x204 with the breakpoint at 0x20A removed", pc)
+	}
+	c.check("?", "S05")
+}
+
+func TestStep(t *testing.T) {
+	c, vm, _ := startServer(t, program)
+	for _, want := range []uint16{0x202, 0x20A, 0x20C, 0x204} {
+		c.check("s", "S05")
+		if pc := vm.Registers().PC; pc != want {
+			t.Errorf("stepped to 0x%03X, want 0x%03X", pc, want)
+		}
+	}
+	c.check("s20a", "S05")
+	if regs := vm.Registers(); regs.PC != 0x20C || regs.V[1] != 14 {
+		t.Errorf("stepped to 0x%03X with V1=%d, want 0x20C with V1=14", regs.PC, regs.V[1])
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	c, vm, _ := startServer(t, program)
+	// Interrupts while stopped are dropped
+	c.write("\x03")
+	c.check("s", "S05")
+	c.send("c")
+	c.expectAck('+')
+	time.Sleep(50 * time.Millisecond)
+	c.write("\x03")
+	if got := c.reply(); got != "S02" {
+		t.Errorf("got %q, want S02", got)
+	}
+	c.check("?", "S02")
+	if regs := vm.Registers(); regs.PC != 0x206 || regs.V[0] != 6 {
+		t.Errorf("interrupted at 0x%03X with V0=%d, want the loop at 0x206 with V0=6", regs.PC, regs.V[0])
+	}
+}
+
+func TestExit(t *testing.T) {
+	// 00FD exits the program
+	c, _, served := startServer(t, []byte{0x00, 0xFD})
+	c.check("c", "W00")
+	c.check("k", "")
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestReadPacketsReturnsWhenDone(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	s := NewServer(nil, serverConn)
+	close(s.done)
+	read := make(chan error, 1)
+	go func() { read <- s.readPackets() }()
+	c := &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	c.send("g")
+	c.expectAck('+')
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Errorf("readPackets: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readPackets blocked handing on a packet after Serve returned")
+	}
+}
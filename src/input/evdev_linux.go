@@ -0,0 +1,87 @@
+package input
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// evdevKeys maps the Linux key codes of the keys in KeyMap to the keypad.
+var evdevKeys = map[uint16]byte{
+	11: 0x0,
+	2: 0x1,
+	3: 0x2,
+	4: 0x3,
+	5: 0x4,
+	6: 0x5,
+	7: 0x6,
+	8: 0x7,
+	9: 0x8,
+	10: 0x9,
+	30: 0xA,
+	48: 0xB,
+	46: 0xC,
+	32: 0xD,
+	18: 0xE,
+	33: 0xF,
+}
+
+// startEvdev reads the keypad from the keyboard devices, which usually
+// requires being in the input group. Unlike the terminal, they report keys
+// pressed while other windows have the focus. It returns a function
+// closing the devices.
+func startEvdev(kb *Keyboard) (func(), bool) {
+	byPath, _ := filepath.Glob("/dev/input/by-path/*-event-kbd")
+	byID, _ := filepath.Glob("/dev/input/by-id/*-event-kbd")
+	opened := make(map[string]bool)
+	var devices []*os.File
+	for _, path := range append(byPath, byID...) {
+		// Both directories link to the same devices
+		device, err := filepath.EvalSymlinks(path)
+		if err != nil || opened[device] {
+			continue
+		}
+		f, err := os.Open(device)
+		if err != nil {
+			continue
+		}
+		opened[device] = true
+		devices = append(devices, f)
+	}
+	if len(devices) == 0 {
+		return nil, false
+	}
+	for _, f := range devices {
+		go kb.readEvdev(f)
+	}
+	return func() {
+		for _, f := range devices {
+			f.Close()
+		}
+	}, true
+}
+
+// readEvdev applies the key events of a device until it is closed.
+func (kb *Keyboard) readEvdev(f *os.File) {
+	// struct input_event is a timeval followed by the type, code and value
+	timeSize := int(unsafe.Sizeof(unix.Timeval{}))
+	event := make([]byte, timeSize + 8)
+	for {
+		if _, err := io.ReadFull(f, event); err != nil {
+			return
+		}
+		typ := binary.NativeEndian.Uint16(event[timeSize:])
+		code := binary.NativeEndian.Uint16(event[timeSize + 2:])
+		value := int32(binary.NativeEndian.Uint32(event[timeSize + 4:]))
+		key, ok := evdevKeys[code]
+		// Values are 0 for releases, 1 for presses and 2 for repeats
+		if typ != unix.EV_KEY || !ok || value == 2 {
+			continue
+		}
+		kb.setKey(key, value == 1)
+	}
+}
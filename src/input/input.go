@@ -10,18 +10,31 @@ import (
 )
 
 
+// Keyboard is the Source reading the keypad from the terminal. Key
+// releases come from the kitty keyboard protocol when the terminal speaks
+// it, or from the keyboard devices on Linux when Evdev is set and they can
+// be read, and are otherwise approximated by releaseDelay passing without
+// the key repeating.
 type Keyboard struct {
-	currentKeysPressed [16]bool
-	prevKeysPressed [16]bool
+	keypadState
+	// Read the keyboard devices, which also report keys typed into other
+	// windows, when the terminal does not speak the kitty protocol
+	Evdev bool
 	tempKeysPressed [16]bool
-	// approximate key releases using delay between key press events
+	// Press times of the keys held when approximating key releases
 	keysDown map[byte]time.Time
+	// Keys outside the keypad, consumed with PollHotkey
+	hotkeys chan rune
 	mu sync.Mutex
+	// Undoes what Start set up, once
+	stop func()
+	stopOnce *sync.Once
 }
 
 const (
 	releaseDelay = time.Second / 5
 	keyChannelSize = 20
+	hotkeyChannelSize = 8
 )
 
 var KeyMap = map[string]byte{
@@ -45,11 +58,32 @@ var KeyMap = map[string]byte{
 
 func (kb *Keyboard) Start() {
 	kb.keysDown = make(map[byte]time.Time)
-	go kb.listner()
+	kb.hotkeys = make(chan rune, hotkeyChannelSize)
+	kb.stopOnce = &sync.Once{}
+	if stop, ok := startKitty(kb); ok {
+		kb.stop = stop
+		return
+	}
+	// The keyboard devices only provide the keypad, the terminal still
+	// provides the hotkeys and keeps keys from being echoed
+	var stopEvdev func()
+	evdev := false
+	if kb.Evdev {
+		stopEvdev, evdev = startEvdev(kb)
+	}
+	kb.stop = func() {
+		keyboard.Close()
+		if evdev {
+			stopEvdev()
+		}
+	}
+	go kb.listner(!evdev)
 }
 
 func (kb *Keyboard) Stop() {
-	keyboard.Close()
+	if kb.stopOnce != nil {
+		kb.stopOnce.Do(kb.stop)
+	}
 }
 
 // DoKeyEventUpdates tracks key presses and releases. It should be called 
@@ -57,62 +91,103 @@ func (kb *Keyboard) Stop() {
 func (kb *Keyboard) DoKeyEventUpdates() {
 	kb.mu.Lock()
 	defer kb.mu.Unlock()
-	kb.prevKeysPressed = kb.currentKeysPressed
-	kb.currentKeysPressed = kb.tempKeysPressed
+	kb.latch(kb.tempKeysPressed)
 }
 
-func (kb *Keyboard) JustPressed(key byte) bool {
-	kb.mu.Lock()
-	defer kb.mu.Unlock()
-	return kb.currentKeysPressed[key] && !kb.prevKeysPressed[key]
+// PollHotkey returns the next pressed key that is not part of
+// the keypad, if any.
+func (kb *Keyboard) PollHotkey() (rune, bool) {
+	select {
+	case key := <-kb.hotkeys:
+		return key, true
+	default:
+		return 0, false
+	}
 }
 
-func (kb *Keyboard) JustReleased(key byte) bool {
+// setKey records a press or release of a keypad key.
+func (kb *Keyboard) setKey(key byte, pressed bool) {
 	kb.mu.Lock()
 	defer kb.mu.Unlock()
-	return !kb.currentKeysPressed[key] && kb.prevKeysPressed[key]
+	kb.tempKeysPressed[key] = pressed
 }
 
-func (kb *Keyboard) IsPressed(key byte) bool {
-	kb.mu.Lock()
-	defer kb.mu.Unlock()
-	return kb.currentKeysPressed[key]
+// sendHotkey queues a key outside the keypad for PollHotkey, dropping it
+// when nobody is polling for them.
+func (kb *Keyboard) sendHotkey(key rune) {
+	select {
+	case kb.hotkeys <- key:
+	default:
+	}
+}
+
+// interrupted handles Ctrl-C, giving the terminal back so that pressing it
+// again kills the program.
+func (kb *Keyboard) interrupted() {
+	fmt.Printf("Press <Ctrl-c> once again to exit!\n")
+	kb.Stop()
 }
 
-func (kb *Keyboard) listner() {
+// listner reads the terminal through eiannone/keyboard, which only reports
+// key presses. Releases of the keypad keys are approximated unless keypad
+// is false, when the keypad keys are ignored.
+func (kb *Keyboard) listner(keypad bool) {
 	// Create a channel to poll for key inputs
 	keysEvents, err := keyboard.GetKeys(keyChannelSize)
 	if err != nil {
 		panic(err)
 	}
 	for {
+		// Sleep until the next key event, or until the earliest held key is
+		// due to be released
+		var releaseDue <-chan time.Time
+		if next, ok := kb.nextRelease(); ok {
+			releaseDue = time.After(time.Until(next))
+		}
 		select {
-		case event := <-keysEvents: {
+		case event, ok := <-keysEvents:
+			if !ok {
+				// Closed by Stop
+				return
+			}
 			pressedChar := strings.ToLower(string(event.Rune))
 			if event.Key == keyboard.KeyCtrlC {
-				fmt.Printf("Press <Ctrl-c> once again to exit!\n")
-				kb.Stop()
-				return	
+				kb.interrupted()
+				return
 			}
 			if charIdx, ok := KeyMap[pressedChar]; ok {
-				kb.mu.Lock()
-				kb.keysDown[charIdx] = time.Now()
-				kb.tempKeysPressed[charIdx] = true
-				kb.mu.Unlock()
+				if keypad {
+					kb.mu.Lock()
+					kb.keysDown[charIdx] = time.Now()
+					kb.tempKeysPressed[charIdx] = true
+					kb.mu.Unlock()
+				}
+			} else if event.Rune != 0 {
+				kb.sendHotkey(event.Rune)
 			}
-		}
-		default: {
-			now := time.Now()
+		case now := <-releaseDue:
+			kb.mu.Lock()
 			for k, t := range kb.keysDown {
 				if now.Sub(t) >= releaseDelay {
-					kb.mu.Lock()
 					// Mark key as released
 					delete(kb.keysDown, k)
 					kb.tempKeysPressed[k] = false
-					kb.mu.Unlock()
 				}
 			}
+			kb.mu.Unlock()
 		}
+	}
+}
+
+// nextRelease returns when the earliest held key is due to be released.
+func (kb *Keyboard) nextRelease() (time.Time, bool) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	var next time.Time
+	for _, t := range kb.keysDown {
+		if next.IsZero() || t.Before(next) {
+			next = t
 		}
 	}
+	return next.Add(releaseDelay), !next.IsZero()
 }
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package input
+
+// Key releases are only read on Linux for now, elsewhere they are always
+// approximated.
+
+func startKitty(kb *Keyboard) (func(), bool) {
+	return nil, false
+}
+
+func startEvdev(kb *Keyboard) (func(), bool) {
+	return nil, false
+}
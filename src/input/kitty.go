@@ -0,0 +1,152 @@
+package input
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The kitty keyboard protocol reports key presses, repeats and releases as
+// CSI keycode[:alternates] ; modifiers[:event] [; text] u, once enabled by
+// pushing flags. See https://sw.kovidgoyal.net/kitty/keyboard-protocol/
+const (
+	// Pushes the flags to disambiguate escape codes (1), report event
+	// types (2) and report all keys as escape codes (8), so that releases
+	// of text keys are reported too
+	kittyPush = "\x1b[>11u"
+	kittyPop = "\x1b[<u"
+	// Asks for the current flags, which only terminals speaking the
+	// protocol answer, followed by the primary device attributes, which all
+	// terminals answer
+	kittyQuery = "\x1b[?u\x1b[c"
+)
+
+const (
+	kittyPress = 1
+	kittyRelease = 3
+	kittyCtrl = 4
+)
+
+// kittyKey is a key event reported by the kitty keyboard protocol.
+type kittyKey struct {
+	code rune
+	modifiers int
+	event int
+}
+
+// readCSI reads the parameters and final byte of a control sequence, the
+// leading ESC [ having been read.
+func readCSI(r *bufio.Reader) (string, byte, error) {
+	var params strings.Builder
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		if b >= 0x40 && b <= 0x7E {
+			return params.String(), b, nil
+		}
+		params.WriteByte(b)
+	}
+}
+
+// parseKittyKey parses the parameters of a CSI u sequence.
+func parseKittyKey(params string) (kittyKey, bool) {
+	key := kittyKey{event: kittyPress}
+	fields := strings.Split(params, ";")
+	code, _, _ := strings.Cut(fields[0], ":")
+	value, err := strconv.Atoi(code)
+	if err != nil {
+		return key, false
+	}
+	key.code = rune(value)
+	if len(fields) > 1 {
+		modifiers, event, hasEvent := strings.Cut(fields[1], ":")
+		if value, err := strconv.Atoi(modifiers); err == nil {
+			// Modifiers are sent as 1 + their bits
+			key.modifiers = value - 1
+		}
+		if hasEvent {
+			if value, err := strconv.Atoi(event); err == nil {
+				key.event = value
+			}
+		}
+	}
+	return key, true
+}
+
+// kittySupported reads the answers to kittyQuery, reporting whether the
+// flags were answered before the device attributes.
+func kittySupported(r *bufio.Reader) bool {
+	supported := false
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if b != 0x1B {
+			continue
+		}
+		if b, err = r.ReadByte(); err != nil {
+			return false
+		}
+		if b != '[' {
+			continue
+		}
+		params, final, err := readCSI(r)
+		if err != nil {
+			return false
+		}
+		switch {
+		case final == 'u' && strings.HasPrefix(params, "?"): supported = true
+		case final == 'c': return supported
+		}
+	}
+}
+
+// readKitty applies the key events reported by the terminal until it is
+// closed.
+func (kb *Keyboard) readKitty(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		// Every key is an escape code, anything else is not for us
+		if b != 0x1B {
+			continue
+		}
+		if b, err = br.ReadByte(); err != nil {
+			return
+		}
+		if b != '[' {
+			continue
+		}
+		params, final, err := readCSI(br)
+		if err != nil {
+			return
+		}
+		if final != 'u' {
+			continue
+		}
+		key, ok := parseKittyKey(params)
+		if !ok {
+			continue
+		}
+		if key.code == 'c' && key.modifiers & kittyCtrl != 0 {
+			if key.event != kittyRelease {
+				kb.interrupted()
+				return
+			}
+			continue
+		}
+		if charIdx, ok := KeyMap[strings.ToLower(string(key.code))]; ok {
+			kb.setKey(charIdx, key.event != kittyRelease)
+		} else if key.event == kittyPress && key.modifiers & kittyCtrl == 0 && unicode.IsPrint(key.code) {
+			kb.sendHotkey(key.code)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package input
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKittyKey(t *testing.T) {
+	tests := []struct {
+		params string
+		want   kittyKey
+		ok     bool
+	}{
+		{params: "97", want: kittyKey{code: 'a', event: kittyPress}, ok: true},
+		{params: "97;1", want: kittyKey{code: 'a', event: kittyPress}, ok: true},
+		{params: "97;1:1", want: kittyKey{code: 'a', event: kittyPress}, ok: true},
+		{params: "97;1:2", want: kittyKey{code: 'a', event: 2}, ok: true},
+		{params: "97;1:3", want: kittyKey{code: 'a', event: kittyRelease}, ok: true},
+		// Shifted keys carry the shifted code as an alternate
+		{params: "97:65;2:3", want: kittyKey{code: 'a', modifiers: 1, event: kittyRelease}, ok: true},
+		{params: "99;5", want: kittyKey{code: 'c', modifiers: kittyCtrl, event: kittyPress}, ok: true},
+		{params: "99;5:3", want: kittyKey{code: 'c', modifiers: kittyCtrl, event: kittyRelease}, ok: true},
+		{params: "112;1;112", want: kittyKey{code: 'p', event: kittyPress}, ok: true},
+		{params: "97;x:y", want: kittyKey{code: 'a', event: kittyPress}, ok: true},
+		{params: "", ok: false},
+		{params: "?11", ok: false},
+	}
+	for _, tc := range tests {
+		key, ok := parseKittyKey(tc.params)
+		if ok != tc.ok || ok && key != tc.want {
+			t.Errorf("parseKittyKey(%q) = %+v, %v, want %+v, %v", tc.params, key, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestKittySupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   bool
+	}{
+		{name: "flags and attributes", answer: "\x1b[?0u\x1b[?62;22c", want: true},
+		{name: "only attributes", answer: "\x1b[?62;22c", want: false},
+		{name: "typed keys first", answer: "ab\x1b[?0u\x1b[?1;2c", want: true},
+		{name: "other sequences first", answer: "\x1b[1;1R\x1bOA\x1b[?1;2c", want: false},
+		{name: "no answer", answer: "", want: false},
+		{name: "cut short", answer: "\x1b[?0u\x1b[?62", want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tc.answer + "rest"))
+			if got := kittySupported(r); got != tc.want {
+				t.Errorf("kittySupported(%q) = %v, want %v", tc.answer, got, tc.want)
+			}
+		})
+	}
+	// Input after the answers is left for the key reader
+	r := bufio.NewReader(strings.NewReader("\x1b[?62c\x1b[97u"))
+	kittySupported(r)
+	if rest, _ := r.ReadString(0); rest != "\x1b[97u" {
+		t.Errorf("kittySupported left %q", rest)
+	}
+}
+
+func TestReadKitty(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// Keypad keys held and hotkeys sent once the input is read
+		want    []byte
+		hotkeys string
+	}{
+		{name: "press", input: "\x1b[49u\x1b[97;1:1u", want: []byte{0x1, 0xA}},
+		{name: "repeat", input: "\x1b[49u\x1b[49;1:2u\x1b[49;1:2u", want: []byte{0x1}},
+		{name: "release", input: "\x1b[49u\x1b[50u\x1b[49;1:3u", want: []byte{0x2}},
+		{name: "shifted", input: "\x1b[102:70;2u", want: []byte{0xF}},
+		{name: "shifted release", input: "\x1b[102:70;2u\x1b[102:70;2:3u", want: nil},
+		{name: "hotkeys", input: "\x1b[112u\x1b[112;1:2u\x1b[112;1:3u\x1b[114u", hotkeys: "pr"},
+		{name: "ctrl hotkey", input: "\x1b[112;5u", hotkeys: ""},
+		{name: "other sequences", input: "x\x1bOA\x1b[A\x1b[1;1R\x1b[51u", want: []byte{0x3}},
+		// Ctrl-C stops reading, ignoring what follows
+		{name: "ctrl-c", input: "\x1b[49u\x1b[99;5u\x1b[50u", want: []byte{0x1}},
+		{name: "ctrl-c release", input: "\x1b[99;5:3u\x1b[50u", want: []byte{0x2}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kb := &Keyboard{hotkeys: make(chan rune, hotkeyChannelSize)}
+			kb.readKitty(strings.NewReader(tc.input))
+			kb.DoKeyEventUpdates()
+			if got := pressed(kb); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("pressed %v, want %v", got, tc.want)
+			}
+			var hotkeys strings.Builder
+			for key, ok := kb.PollHotkey(); ok; key, ok = kb.PollHotkey() {
+				hotkeys.WriteRune(key)
+			}
+			if hotkeys.String() != tc.hotkeys {
+				t.Errorf("hotkeys %q, want %q", hotkeys.String(), tc.hotkeys)
+			}
+		})
+	}
+}
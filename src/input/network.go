@@ -0,0 +1,110 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Network is a Source fed by controllers connecting over TCP. Each line
+// they send is "press K" or "release K" with K a hexadecimal key, or the
+// state of the whole keypad as a 4 digit hexadecimal mask, bit n being
+// set when key n is pressed. Invalid lines are answered with an error.
+type Network struct {
+	keypadState
+	listener net.Listener
+	mu sync.Mutex
+	keys [16]bool
+	conns map[net.Conn]bool
+}
+
+// ListenNetwork listens for controllers on addr, such as ":5555".
+func ListenNetwork(addr string) (*Network, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on '%s': %v", addr, err)
+	}
+	return &Network{listener: listener, conns: make(map[net.Conn]bool)}, nil
+}
+
+// Addr is the address controllers connect to.
+func (n *Network) Addr() net.Addr {
+	return n.listener.Addr()
+}
+
+func (n *Network) Start() {
+	go n.accept()
+}
+
+// Stop disconnects the controllers and stops listening.
+func (n *Network) Stop() {
+	n.listener.Close()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for conn := range n.conns {
+		conn.Close()
+	}
+}
+
+func (n *Network) DoKeyEventUpdates() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latch(n.keys)
+}
+
+func (n *Network) accept() {
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			return
+		}
+		n.mu.Lock()
+		n.conns[conn] = true
+		n.mu.Unlock()
+		go n.serve(conn)
+	}
+}
+
+func (n *Network) serve(conn net.Conn) {
+	defer func() {
+		n.mu.Lock()
+		delete(n.conns, conn)
+		n.mu.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if err := n.handle(strings.Fields(scanner.Text())); err != nil {
+			fmt.Fprintf(conn, "err: %v\n", err)
+		}
+	}
+}
+
+func (n *Network) handle(fields []string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	switch {
+	case len(fields) == 0:
+		return nil
+	case len(fields) == 1:
+		mask, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			return fmt.Errorf("invalid keypad state '%s'", fields[0])
+		}
+		for idx := range n.keys {
+			n.keys[idx] = mask >> idx & 1 == 1
+		}
+	case len(fields) == 2 && (fields[0] == "press" || fields[0] == "release"):
+		key, err := strconv.ParseUint(fields[1], 16, 4)
+		if err != nil {
+			return fmt.Errorf("invalid key '%s'", fields[1])
+		}
+		n.keys[key] = fields[0] == "press"
+	default:
+		return fmt.Errorf("expected 'press K', 'release K' or a keypad mask")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetworkHandle(t *testing.T) {
+	tests := []struct {
+		line string
+		// Keys held before the line
+		before []byte
+		want   []byte
+		err    string
+	}{
+		{line: "", before: []byte{0x1}, want: []byte{0x1}},
+		{line: "press a", want: []byte{0xA}},
+		{line: "press F", before: []byte{0x1}, want: []byte{0x1, 0xF}},
+		{line: "  press   0 ", want: []byte{0x0}},
+		{line: "release 1", before: []byte{0x1, 0x2}, want: []byte{0x2}},
+		{line: "release 3", before: []byte{0x1}, want: []byte{0x1}},
+		{line: "8001", before: []byte{0x4}, want: []byte{0x0, 0xF}},
+		{line: "0000", before: []byte{0x4, 0x5}, want: nil},
+		{line: "ffff", want: []byte{0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9, 0xA, 0xB, 0xC, 0xD, 0xE, 0xF}},
+		{line: "12", want: []byte{0x1, 0x4}},
+		{line: "10000", before: []byte{0x1}, want: []byte{0x1}, err: "invalid keypad state '10000'"},
+		{line: "press", before: []byte{0x1}, want: []byte{0x1}, err: "invalid keypad state 'press'"},
+		{line: "press 10", want: nil, err: "invalid key '10'"},
+		{line: "release x", before: []byte{0x1}, want: []byte{0x1}, err: "invalid key 'x'"},
+		{line: "hold 1", want: nil, err: "expected 'press K', 'release K' or a keypad mask"},
+		{line: "press 1 2", want: nil, err: "expected 'press K', 'release K' or a keypad mask"},
+	}
+	for _, tc := range tests {
+		n := &Network{}
+		for _, key := range tc.before {
+			n.keys[key] = true
+		}
+		err := n.handle(strings.Fields(tc.line))
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("handle(%q) = %v, want %q", tc.line, err, tc.err)
+		}
+		n.DoKeyEventUpdates()
+		if got := pressed(n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("handle(%q) with %v held: pressed %v, want %v", tc.line, tc.before, got, tc.want)
+		}
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	n, err := ListenNetwork("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Start()
+	defer n.Stop()
+	conn, err := net.Dial("tcp", n.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	replies := bufio.NewReader(conn)
+	// Invalid lines are answered, valid ones are not
+	fmt.Fprintf(conn, "press 2\nhold 3\npress 5\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := replies.ReadString('\n')
+	if err != nil || reply != "err: expected 'press K', 'release K' or a keypad mask\n" {
+		t.Fatalf("got reply %q, %v", reply, err)
+	}
+	// Once a later line is answered, the ones before it were handled
+	fmt.Fprintf(conn, "zz\n")
+	if reply, err := replies.ReadString('\n'); err != nil || reply != "err: invalid keypad state 'zz'\n" {
+		t.Fatalf("got reply %q, %v", reply, err)
+	}
+	n.DoKeyEventUpdates()
+	if got := pressed(n); !reflect.DeepEqual(got, []byte{0x2, 0x5}) {
+		t.Errorf("pressed %v, want [2 5]", got)
+	}
+	// Stopping disconnects the controllers
+	n.Stop()
+	if _, err := replies.ReadString('\n'); err == nil {
+		t.Error("controller still connected after Stop")
+	}
+}
@@ -0,0 +1,35 @@
+package input
+
+// Replay is a Source playing back the keypad state of each frame, as
+// recorded in movies. Bit n of a frame's state is set when key n is
+// pressed.
+type Replay struct {
+	keypadState
+	frames []uint16
+	frame int
+}
+
+func NewReplay(frames []uint16) *Replay {
+	return &Replay{frames: frames}
+}
+
+func (r *Replay) Start() {}
+
+func (r *Replay) Stop() {}
+
+func (r *Replay) DoKeyEventUpdates() {
+	if r.frame >= len(r.frames) {
+		return
+	}
+	var keys [16]bool
+	for idx := range keys {
+		keys[idx] = r.frames[r.frame] >> idx & 1 == 1
+	}
+	r.latch(keys)
+	r.frame++
+}
+
+// Done reports whether all the frames have been played.
+func (r *Replay) Done() bool {
+	return r.frame >= len(r.frames)
+}
@@ -0,0 +1,95 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ScriptEvent presses or releases a key at the start of a frame.
+type ScriptEvent struct {
+	Frame int
+	Key byte
+	Pressed bool
+}
+
+// Script is a Source driven by timed events, or by the program embedding
+// the VM calling Press and Release, so that no terminal is needed.
+type Script struct {
+	keypadState
+	mu sync.Mutex
+	keys [16]bool
+	// Events not applied yet, ordered by frame
+	events []ScriptEvent
+	frame int
+}
+
+// NewScript creates a script applying events as their frames come.
+func NewScript(events ...ScriptEvent) *Script {
+	events = append([]ScriptEvent{}, events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Frame < events[j].Frame })
+	return &Script{events: events}
+}
+
+// ParseScript reads events written one per line as "FRAME KEY down" or
+// "FRAME KEY up", with KEY in hexadecimal. # starts a comment.
+func ParseScript(r io.Reader) ([]ScriptEvent, error) {
+	var events []ScriptEvent
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 || fields[2] != "down" && fields[2] != "up" {
+			return nil, fmt.Errorf("line %d: expected FRAME KEY down|up", lineNo)
+		}
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("line %d: invalid frame '%s'", lineNo, fields[0])
+		}
+		key, err := strconv.ParseUint(fields[1], 16, 4)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid key '%s'", lineNo, fields[1])
+		}
+		events = append(events, ScriptEvent{Frame: frame, Key: byte(key), Pressed: fields[2] == "down"})
+	}
+	return events, scanner.Err()
+}
+
+// Press holds a key down from the next frame on.
+func (s *Script) Press(key byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key & 0xF] = true
+}
+
+// Release lets go of a key from the next frame on.
+func (s *Script) Release(key byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key & 0xF] = false
+}
+
+func (s *Script) Start() {}
+
+func (s *Script) Stop() {}
+
+func (s *Script) DoKeyEventUpdates() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.events) > 0 && s.events[0].Frame <= s.frame {
+		s.keys[s.events[0].Key & 0xF] = s.events[0].Pressed
+		s.events = s.events[1:]
+	}
+	s.latch(s.keys)
+	s.frame++
+}
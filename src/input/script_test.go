@@ -0,0 +1,106 @@
+package input
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	script := `# jump, then run right
+0 5 down
+  2 5 up   # let go
+10 a down
+
+10 F up
+`
+	events, err := ParseScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ScriptEvent{
+		{Frame: 0, Key: 0x5, Pressed: true},
+		{Frame: 2, Key: 0x5, Pressed: false},
+		{Frame: 10, Key: 0xA, Pressed: true},
+		{Frame: 10, Key: 0xF, Pressed: false},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %+v, want %+v", events, want)
+	}
+	errors := []struct {
+		script string
+		want   string
+	}{
+		{"0 5 DOWN\n", "line 1: expected FRAME KEY down|up"},
+		{"0 5\n", "line 1: expected FRAME KEY down|up"},
+		{"0 5 down now\n", "line 1: expected FRAME KEY down|up"},
+		{"# start\nx 5 down\n", "line 2: invalid frame 'x'"},
+		{"-1 5 down\n", "line 1: invalid frame '-1'"},
+		{"0 10 down\n", "line 1: invalid key '10'"},
+		{"0 g up\n", "line 1: invalid key 'g'"},
+	}
+	for _, tc := range errors {
+		if _, err := ParseScript(strings.NewReader(tc.script)); err == nil || err.Error() != tc.want {
+			t.Errorf("ParseScript(%q) = %v, want %q", tc.script, err, tc.want)
+		}
+	}
+}
+
+// pressed lists the keys held in the latched state.
+func pressed(s Source) []byte {
+	var keys []byte
+	for key := byte(0); key < 16; key++ {
+		if s.IsPressed(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestScript(t *testing.T) {
+	// Out of order, with events of the same frame applied in the order
+	// given
+	s := NewScript(
+		ScriptEvent{Frame: 3, Key: 0x2, Pressed: false},
+		ScriptEvent{Frame: 1, Key: 0x2, Pressed: true},
+		ScriptEvent{Frame: 2, Key: 0x7, Pressed: true},
+		ScriptEvent{Frame: 2, Key: 0x7, Pressed: false},
+		ScriptEvent{Frame: 2, Key: 0x9, Pressed: false},
+		ScriptEvent{Frame: 2, Key: 0x9, Pressed: true},
+		ScriptEvent{Frame: 0, Key: 0x1, Pressed: true},
+	)
+	want := [][]byte{
+		{0x1},
+		{0x1, 0x2},
+		{0x1, 0x2, 0x9},
+		{0x1, 0x9},
+		{0x1, 0x9},
+	}
+	for frame, keys := range want {
+		s.DoKeyEventUpdates()
+		if got := pressed(s); !reflect.DeepEqual(got, keys) {
+			t.Errorf("frame %d: pressed %v, want %v", frame, got, keys)
+		}
+	}
+	if s.JustPressed(0x9) || s.JustReleased(0x2) {
+		t.Error("keys unchanged since the previous frame reported as just pressed or released")
+	}
+
+	// Press and Release take effect from the next frame on, and events
+	// still apply on top of them
+	s = NewScript(ScriptEvent{Frame: 1, Key: 0x3, Pressed: false})
+	s.Press(0x3)
+	s.Press(0x14)
+	if len(pressed(s)) != 0 {
+		t.Error("Press took effect before the next frame")
+	}
+	s.DoKeyEventUpdates()
+	if got := pressed(s); !reflect.DeepEqual(got, []byte{0x3, 0x4}) || !s.JustPressed(0x3) {
+		t.Errorf("frame 0: pressed %v, want [3 4] just pressed", got)
+	}
+	s.Release(0x4)
+	s.DoKeyEventUpdates()
+	if got := pressed(s); len(got) != 0 || !s.JustReleased(0x3) || !s.JustReleased(0x4) {
+		t.Errorf("frame 1: pressed %v, want 3 and 4 just released", got)
+	}
+}
@@ -0,0 +1,53 @@
+package input
+
+// Source provides the state of the 16-key keypad. The VM calls
+// DoKeyEventUpdates once per frame, and the other methods report the
+// state latched by the last call, so that a key pressed and released
+// within a frame is not half seen.
+type Source interface {
+	// Start and Stop bracket a run of the VM
+	Start()
+	Stop()
+	DoKeyEventUpdates()
+	IsPressed(key byte) bool
+	JustPressed(key byte) bool
+	JustReleased(key byte) bool
+}
+
+// HotkeySource is implemented by sources that also report keys outside
+// the keypad, such as the terminal.
+type HotkeySource interface {
+	// PollHotkey returns the next pressed key that is not part of the
+	// keypad, if any.
+	PollHotkey() (rune, bool)
+}
+
+// FiniteSource is implemented by sources that run out of input, such as
+// replays. The VM ends the program once they are done.
+type FiniteSource interface {
+	Done() bool
+}
+
+// keypadState holds the latched state of the current and previous frame,
+// for sources to embed.
+type keypadState struct {
+	current [16]bool
+	prev [16]bool
+}
+
+func (s *keypadState) latch(keys [16]bool) {
+	s.prev = s.current
+	s.current = keys
+}
+
+func (s *keypadState) IsPressed(key byte) bool {
+	return s.current[key & 0xF]
+}
+
+func (s *keypadState) JustPressed(key byte) bool {
+	return s.current[key & 0xF] && !s.prev[key & 0xF]
+}
+
+func (s *keypadState) JustReleased(key byte) bool {
+	return !s.current[key & 0xF] && s.prev[key & 0xF]
+}
@@ -0,0 +1,67 @@
+package input
+
+import (
+	"bufio"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// How long to wait for the terminal to answer kittyQuery
+const kittyQueryTimeout = time.Second / 5
+
+// startKitty reads the keypad through the kitty keyboard protocol, if the
+// terminal speaks it. It returns a function restoring the terminal.
+func startKitty(kb *Keyboard) (func(), bool) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, false
+	}
+	// Fd would put the terminal in blocking mode, ignoring read deadlines
+	conn, err := tty.SyscallConn()
+	if err != nil {
+		tty.Close()
+		return nil, false
+	}
+	var fd int
+	conn.Control(func(ttyFd uintptr) { fd = int(ttyFd) })
+	orig, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		tty.Close()
+		return nil, false
+	}
+	raw := *orig
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		tty.Close()
+		return nil, false
+	}
+	restore := func() {
+		unix.IoctlSetTermios(fd, unix.TCSETS, orig)
+		tty.Close()
+	}
+	// Terminals not answering at all, or not speaking the protocol, fall
+	// back to the other ways of reading keys
+	tty.SetReadDeadline(time.Now().Add(kittyQueryTimeout))
+	r := bufio.NewReader(tty)
+	if _, err := tty.WriteString(kittyQuery); err != nil || !kittySupported(r) {
+		restore()
+		return nil, false
+	}
+	tty.SetReadDeadline(time.Time{})
+	if _, err := tty.WriteString(kittyPush); err != nil {
+		restore()
+		return nil, false
+	}
+	go kb.readKitty(r)
+	return func() {
+		tty.WriteString(kittyPop)
+		restore()
+	}, true
+}
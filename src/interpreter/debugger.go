@@ -0,0 +1,497 @@
+package interpreter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	common "github.com/abhinand20/emugo/common"
+)
+
+const (
+	// Instructions listed by "dis" when no count is given
+	defaultDisassembly = 8
+	// Bytes dumped by "x" when no count is given
+	defaultExamine = 16
+)
+
+const debuggerHelp = `Commands:
+  b, break ADDR [if EXPR]
+                        stop before the instruction at ADDR, optionally
+                        only when EXPR is true
+  d, delete [ADDR]      remove the breakpoint at ADDR, or all of them
+  bl, breakpoints       list breakpoints and watchpoints
+  watch ADDR [N]        stop after N bytes at ADDR are written
+  rwatch ADDR [N]       stop after they are read
+  awatch ADDR [N]       stop after they are read or written
+  watch EXPR            stop when the value of EXPR changes, e.g. V3
+  when EXPR             stop when EXPR becomes true
+  unwatch [N]           remove watchpoint N, or all of them
+  c, continue           run until a breakpoint
+  s, step [N]           run N instructions (default 1)
+  n, next               step, running over subroutine calls
+  f, finish             run until the current subroutine returns
+  back [N]              go back N instructions (default 1)
+  rewind [N]            go back to the start of the Nth previous frame
+                        (default 1)
+  r, regs               print registers, timers and the stack
+  x ADDR [N]            dump N bytes of memory (default 16)
+  set ADDR BYTE...      write bytes to memory
+  set REG VALUE         set V0-VF, I, PC, DT or ST
+  dis [ADDR] [N]        disassemble N instructions (default around PC)
+  screen                print the display
+  q, quit               stop the program
+Numbers are decimal unless prefixed with 0x. Expressions use the
+registers V0-VF, I, PC, SP, DT and ST, [ADDR] for a byte of memory, and
+C operators, e.g. "V3 == 0x10 && I > 0x300". An empty line repeats the
+last command.`
+
+// Debugger is an interactive console that runs before each instruction,
+// stopping at breakpoints, watchpoints and after steps.
+type Debugger struct {
+	vm *VirtualMachine
+	in *bufio.Reader
+	out io.Writer
+	breakpoints map[uint16]*breakpoint
+	watches []*watchpoint
+	// Watchpoints hit by the last instruction
+	hits []string
+	// Address of the last instruction, for watchpoint reports
+	lastPC uint16
+	// Stop before the first instruction
+	started bool
+	// Instructions left to run before stopping, when stepping
+	stepping bool
+	steps int
+	// Stop when the stack drops below this depth, -1 when unset
+	finishDepth int
+	// Stop on returning from a stepped over call, -1 when unset
+	returnAddr int
+	returnDepth uint16
+	lastCommand string
+}
+
+// NewDebugger creates a debugger for vm reading commands from in.
+func NewDebugger(vm *VirtualMachine, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		vm: vm,
+		in: bufio.NewReader(in),
+		out: out,
+		breakpoints: make(map[uint16]*breakpoint),
+		finishDepth: -1,
+		returnAddr: -1,
+	}
+}
+
+type breakpoint struct {
+	// Optional condition, nil to always stop
+	cond expr
+	text string
+}
+
+// AddBreakpoint stops the VM before the instruction at addr runs.
+func (d *Debugger) AddBreakpoint(addr uint16) {
+	d.breakpoints[addr] = &breakpoint{}
+}
+
+// beforeStep runs before each instruction, prompting for commands
+// when the VM should stop. It reports whether to quit.
+func (d *Debugger) beforeStep() bool {
+	reason, stop := d.stopReason()
+	if stop {
+		d.stepping = false
+		d.finishDepth = -1
+		d.returnAddr = -1
+		if len(reason) > 0 {
+			fmt.Fprintln(d.out, reason)
+		}
+		d.printCurrent()
+		if quit := d.prompt(); quit {
+			return true
+		}
+	}
+	if d.stepping {
+		d.steps--
+	}
+	d.lastPC = d.vm.pc
+	return false
+}
+
+func (d *Debugger) stopReason() (string, bool) {
+	vm := d.vm
+	if !d.started {
+		d.started = true
+		return "", true
+	}
+	d.checkExprWatches()
+	if len(d.hits) > 0 {
+		reason := strings.Join(d.hits, "\n")
+		d.hits = nil
+		return reason, true
+	}
+	if bp, ok := d.breakpoints[vm.pc]; ok && (bp.cond == nil || bp.cond(vm) != 0) {
+		return fmt.Sprintf("Breakpoint at 0x%03X", vm.pc), true
+	}
+	switch {
+	case d.stepping && d.steps <= 0:
+		return "", true
+	case d.returnAddr >= 0 && int(vm.pc) == d.returnAddr && vm.sp == d.returnDepth:
+		return "", true
+	case d.finishDepth >= 0 && int(vm.sp) < d.finishDepth:
+		return fmt.Sprintf("Returned to 0x%03X", vm.pc), true
+	}
+	return "", false
+}
+
+// prompt reads commands until one resumes execution.
+func (d *Debugger) prompt() bool {
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && len(line) == 0 {
+			// Input closed
+			return true
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			line = d.lastCommand
+		}
+		d.lastCommand = line
+		resume, quit, err := d.command(strings.Fields(line))
+		if err != nil {
+			fmt.Fprintf(d.out, "err: %v\n", err)
+			continue
+		}
+		if quit {
+			return true
+		}
+		if resume {
+			return false
+		}
+	}
+}
+
+// command runs a debugger command, reporting whether to resume execution
+// or quit.
+func (d *Debugger) command(args []string) (bool, bool, error) {
+	if len(args) == 0 {
+		return false, false, nil
+	}
+	vm := d.vm
+	switch args[0] {
+	case "b", "break":
+		if len(args) < 2 || len(args) == 3 || len(args) > 3 && args[2] != "if" {
+			return false, false, fmt.Errorf("usage: break ADDR [if EXPR]")
+		}
+		addr, err := parseValue(args[1], 0xFFFF)
+		if err != nil {
+			return false, false, err
+		}
+		bp := &breakpoint{}
+		if len(args) > 3 {
+			bp.text = strings.Join(args[3:], " ")
+			if bp.cond, err = parseExpr(bp.text); err != nil {
+				return false, false, err
+			}
+		}
+		d.breakpoints[uint16(addr)] = bp
+		fmt.Fprintf(d.out, "Breakpoint at %s\n", bp.describe(uint16(addr)))
+	case "d", "delete":
+		if len(args) == 1 {
+			d.breakpoints = make(map[uint16]*breakpoint)
+			return false, false, nil
+		}
+		addr, err := parseValue(args[1], 0xFFFF)
+		if err != nil {
+			return false, false, err
+		}
+		if _, ok := d.breakpoints[uint16(addr)]; !ok {
+			return false, false, fmt.Errorf("no breakpoint at 0x%03X", addr)
+		}
+		delete(d.breakpoints, uint16(addr))
+	case "bl", "breakpoints":
+		d.printBreakpoints()
+		d.printWatches()
+	case "watch":
+		if len(args) < 2 {
+			return false, false, fmt.Errorf("usage: watch ADDR [N] or watch EXPR")
+		}
+		// Numbers are addresses, anything else an expression
+		if isValue(args[1]) && (len(args) == 2 || len(args) == 3 && isValue(args[2])) {
+			return false, false, d.addMemoryWatch(watchWrite, args[1:])
+		}
+		return false, false, d.addExprWatch(watchChange, args[1:])
+	case "rwatch":
+		return false, false, d.addMemoryWatch(watchRead, args[1:])
+	case "awatch":
+		return false, false, d.addMemoryWatch(watchRead | watchWrite, args[1:])
+	case "when":
+		if len(args) < 2 {
+			return false, false, fmt.Errorf("usage: when EXPR")
+		}
+		return false, false, d.addExprWatch(watchWhen, args[1:])
+	case "unwatch":
+		return false, false, d.removeWatch(args[1:])
+	case "c", "continue":
+		return true, false, nil
+	case "s", "step":
+		steps := 1
+		if len(args) > 1 {
+			n, err := parseValue(args[1], 1 << 30)
+			if err != nil {
+				return false, false, err
+			}
+			steps = max(1, n)
+		}
+		d.stepping, d.steps = true, steps
+		return true, false, nil
+	case "n", "next":
+		if inst := d.decode(vm.pc); inst.Op == common.OpCALL {
+			d.returnAddr, d.returnDepth = int(vm.pc) + inst.Size, vm.sp
+		} else {
+			d.stepping, d.steps = true, 1
+		}
+		return true, false, nil
+	case "f", "finish":
+		if vm.sp == 0 {
+			return false, false, fmt.Errorf("not in a subroutine")
+		}
+		d.finishDepth = int(vm.sp)
+		return true, false, nil
+	case "back", "rewind":
+		n := 1
+		if len(args) > 1 {
+			var err error
+			if n, err = parseValue(args[1], 1 << 30); err != nil || n == 0 {
+				return false, false, fmt.Errorf("invalid count '%s'", args[1])
+			}
+		}
+		if args[0] == "back" {
+			if err := vm.StepBack(n); err != nil {
+				return false, false, err
+			}
+		} else {
+			frames, err := vm.RewindFrames(n)
+			if err != nil {
+				return false, false, err
+			}
+			fmt.Fprintf(d.out, "Rewound %d frames\n", frames)
+		}
+		d.refreshExprWatches()
+		d.printCurrent()
+	case "r", "regs":
+		d.printRegisters()
+	case "x":
+		return false, false, d.examine(args[1:])
+	case "set":
+		return false, false, d.set(args[1:])
+	case "dis":
+		return false, false, d.disassemble(args[1:])
+	case "screen":
+		if s, ok := vm.Display.(fmt.Stringer); ok {
+			fmt.Fprint(d.out, s.String())
+		} else {
+			return false, false, fmt.Errorf("display cannot be printed")
+		}
+	case "h", "help":
+		fmt.Fprintln(d.out, debuggerHelp)
+	case "q", "quit":
+		return false, true, nil
+	default:
+		return false, false, fmt.Errorf("unknown command '%s', try 'help'", args[0])
+	}
+	return false, false, nil
+}
+
+func (d *Debugger) decode(addr uint16) common.Instruction {
+	return d.vm.decode(addr)
+}
+
+func (d *Debugger) printCurrent() {
+	inst := d.decode(d.vm.pc)
+	fmt.Fprintf(d.out, "> %s\n", inst.Line())
+}
+
+func (d *Debugger) printBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints")
+		return
+	}
+	var addrs []int
+	for addr := range d.breakpoints {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for _, addr := range addrs {
+		fmt.Fprintln(d.out, d.breakpoints[uint16(addr)].describe(uint16(addr)))
+	}
+}
+
+func (bp *breakpoint) describe(addr uint16) string {
+	if bp.cond == nil {
+		return fmt.Sprintf("0x%03X", addr)
+	}
+	return fmt.Sprintf("0x%03X if %s", addr, bp.text)
+}
+
+func (d *Debugger) printRegisters() {
+	regs := d.vm.Registers()
+	for idx, v := range regs.V {
+		fmt.Fprintf(d.out, "V%X=%02X", idx, v)
+		if idx % 8 == 7 {
+			fmt.Fprintln(d.out)
+		} else {
+			fmt.Fprint(d.out, " ")
+		}
+	}
+	fmt.Fprintf(d.out, "I=%04X PC=%04X SP=%d DT=%02X ST=%02X\n", regs.I, regs.PC, regs.SP, regs.DT, regs.ST)
+	fmt.Fprint(d.out, "Stack:")
+	for idx := 1; idx <= int(regs.SP) && idx < len(regs.Stack); idx++ {
+		fmt.Fprintf(d.out, " %04X", regs.Stack[idx])
+	}
+	fmt.Fprintln(d.out)
+}
+
+func (d *Debugger) examine(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: x ADDR [N]")
+	}
+	addr, err := parseValue(args[0], 0xFFFF)
+	if err != nil {
+		return err
+	}
+	n := defaultExamine
+	if len(args) == 2 {
+		if n, err = parseValue(args[1], 0x10000); err != nil {
+			return err
+		}
+	}
+	data, err := d.vm.ReadMemory(addr, min(n, len(d.vm.memory) - addr))
+	if err != nil {
+		return err
+	}
+	for offset := 0; offset < len(data); offset += 16 {
+		line := data[offset:min(offset + 16, len(data))]
+		hex := make([]string, len(line))
+		for idx, b := range line {
+			hex[idx] = fmt.Sprintf("%02X", b)
+		}
+		fmt.Fprintf(d.out, "%04X: %s\n", addr + offset, strings.Join(hex, " "))
+	}
+	return nil
+}
+
+func (d *Debugger) set(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: set ADDR BYTE... or set REG VALUE")
+	}
+	regs := d.vm.Registers()
+	target := strings.ToUpper(args[0])
+	if len(target) == 2 && target[0] == 'V' {
+		if x, err := strconv.ParseUint(target[1:], 16, 8); err == nil {
+			value, err := parseValue(args[1], 0xFF)
+			if err != nil {
+				return err
+			}
+			regs.V[x] = uint8(value)
+			return d.vm.SetRegisters(regs)
+		}
+	}
+	switch target {
+	case "I", "PC":
+		value, err := parseValue(args[1], 0xFFFF)
+		if err != nil {
+			return err
+		}
+		if target == "I" {
+			regs.I = uint16(value)
+		} else {
+			regs.PC = uint16(value)
+		}
+		return d.vm.SetRegisters(regs)
+	case "DT", "ST":
+		value, err := parseValue(args[1], 0xFF)
+		if err != nil {
+			return err
+		}
+		if target == "DT" {
+			regs.DT = uint8(value)
+		} else {
+			regs.ST = uint8(value)
+		}
+		return d.vm.SetRegisters(regs)
+	}
+	addr, err := parseValue(args[0], 0xFFFF)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, len(args) - 1)
+	for idx, arg := range args[1:] {
+		value, err := parseValue(arg, 0xFF)
+		if err != nil {
+			return err
+		}
+		data[idx] = byte(value)
+	}
+	return d.vm.WriteMemory(addr, data)
+}
+
+// disassemble lists instructions from ADDR, or from a few instructions
+// before PC when no address is given.
+func (d *Debugger) disassemble(args []string) error {
+	pc := int(d.vm.pc)
+	addr := max(0, pc - 3 * 2)
+	n := defaultDisassembly
+	var err error
+	if len(args) > 0 {
+		if addr, err = parseValue(args[0], 0xFFFF); err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		if n, err = parseValue(args[1], 0x10000); err != nil {
+			return err
+		}
+	}
+	for count := 0; count < n && addr < len(d.vm.memory); count++ {
+		inst := d.decode(uint16(addr))
+		marker := " "
+		if addr == pc {
+			marker = ">"
+		}
+		if _, ok := d.breakpoints[uint16(addr)]; ok {
+			marker = "*" + marker
+		} else {
+			marker = " " + marker
+		}
+		fmt.Fprintf(d.out, "%s %s\n", marker, inst.Line())
+		addr += inst.Size
+	}
+	return nil
+}
+
+// parseNumber reads a decimal or 0x prefixed hex number, so that a
+// leading 0 is not taken for octal.
+func parseNumber(s string, bitSize int) (uint64, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseUint(s[2:], 16, bitSize)
+	}
+	return strconv.ParseUint(s, 10, bitSize)
+}
+
+func isValue(s string) bool {
+	_, err := parseNumber(s, 32)
+	return err == nil
+}
+
+// parseValue reads a decimal or 0x prefixed hex number up to limit.
+func parseValue(s string, limit int) (int, error) {
+	value, err := parseNumber(s, 32)
+	if err != nil || int(value) > limit {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+	return int(value), nil
+}
@@ -0,0 +1,216 @@
+package interpreter_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+// Calls a subroutine twice, at 0x200 and 0x204
+var callProgram = []byte{
+	0x60, 0x05, // 200: LD V0, 0x05
+	0x22, 0x0C, // 202: CALL 0x20C
+	0x22, 0x0C, // 204: CALL 0x20C
+	0x70, 0x01, // 206: ADD V0, 0x01
+	0x12, 0x08, // 208: JP 0x208
+	0x00, 0x00,
+	0x71, 0x07, // 20C: ADD V1, 0x07
+	0x72, 0x01, // 20E: ADD V2, 0x01
+	0x00, 0xEE, // 210: RET
+}
+
+// runDebugger runs the program under a debugger fed with the commands,
+// until they run out, and returns the VM and the console output.
+func runDebugger(t *testing.T, program []byte, commands ...string) (*interpreter.VirtualMachine, string) {
+	t.Helper()
+	var out bytes.Buffer
+	vm := &interpreter.VirtualMachine{
+		Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+		Rewind:  interpreter.NewRewind(10),
+	}
+	vm.Debugger = interpreter.NewDebugger(vm, strings.NewReader(strings.Join(commands, "\n")+"\n"), &out)
+	if err := vm.Init(program, 700); err != nil {
+		t.Fatal(err)
+	}
+	end, err := vm.RunCycles(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !end {
+		t.Fatalf("debugger did not stop the VM, output:\n%s", out.String())
+	}
+	return vm, out.String()
+}
+
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  []byte
+		commands []string
+		// Substrings expected in the output, in order
+		want []string
+		pc   uint16
+		v    map[int]byte
+	}{
+		{
+			name:     "stops before the first instruction",
+			program:  callProgram,
+			commands: nil,
+			want:     []string{"> 0200: 6005", "(debug) "},
+			pc:       0x200,
+		},
+		{
+			name:     "step",
+			program:  callProgram,
+			commands: []string{"step", "step"},
+			want:     []string{"> 0200:", "> 0202:", "> 020C:"},
+			pc:       0x20C,
+			v:        map[int]byte{0: 5},
+		},
+		{
+			name:     "step count and repeat",
+			program:  callProgram,
+			commands: []string{"step 3", ""},
+			want:     []string{"> 020E:", "> 020C:"},
+			pc:       0x20C,
+			v:        map[int]byte{0: 5, 1: 7, 2: 1},
+		},
+		{
+			name:     "next runs over calls",
+			program:  callProgram,
+			commands: []string{"next", "next", "next"},
+			want:     []string{"> 0202:", "> 0204:", "> 0206:"},
+			pc:       0x206,
+			v:        map[int]byte{1: 14, 2: 2},
+		},
+		{
+			name:     "finish",
+			program:  callProgram,
+			commands: []string{"finish", "step 2", "finish"},
+			want:     []string{"err: not in a subroutine", "> 020C:", "Returned to 0x204"},
+			pc:       0x204,
+			v:        map[int]byte{1: 7},
+		},
+		{
+			name:     "breakpoint",
+			program:  callProgram,
+			commands: []string{"break 0x210", "continue", "regs"},
+			want:     []string{"Breakpoint at 0x210", "Breakpoint at 0x210", "> 0210: 00EE RET", "V0=05 V1=07 V2=01", "SP=1", "Stack: 0204"},
+			pc:       0x210,
+		},
+		{
+			name:     "conditional breakpoint",
+			program:  callProgram,
+			commands: []string{"b 0x20E if V1 == 14", "c"},
+			want:     []string{"Breakpoint at 0x20E if V1 == 14", "Breakpoint at 0x20E"},
+			pc:       0x20E,
+			v:        map[int]byte{1: 14, 2: 1},
+		},
+		{
+			name:     "delete and list breakpoints",
+			program:  callProgram,
+			commands: []string{"break 0x206", "break 0x210", "delete 0x210", "bl", "delete 0x300", "delete", "bl"},
+			want:     []string{"0x206\nNo watchpoints", "err: no breakpoint at 0x300", "No breakpoints"},
+			pc:       0x200,
+		},
+		{
+			name:     "set and examine",
+			program:  callProgram,
+			commands: []string{"set V3 0x2A", "set I 0x300", "set 0x300 1 2 0xFF", "x 0x300 4", "set DT 9", "r"},
+			want:     []string{"0300: 01 02 FF 00", "V3=2A", "I=0300 PC=0200 SP=0 DT=09"},
+			pc:       0x200,
+			v:        map[int]byte{3: 0x2A},
+		},
+		{
+			name:     "decimal with leading zero",
+			program:  callProgram,
+			commands: []string{"set V3 010", "set 0x300 010 0x10", "x 0x300 2", "set V4 0b1", "set V4 0o7", "set V4 1_0", "r"},
+			want: []string{
+				"0300: 0A 10",
+				"err: invalid value '0b1'",
+				"err: invalid value '0o7'",
+				"err: invalid value '1_0'",
+				"V3=0A V4=00",
+			},
+			pc: 0x200,
+			v:  map[int]byte{3: 10},
+		},
+		{
+			name:     "set PC",
+			program:  callProgram,
+			commands: []string{"set pc 0x206", "step"},
+			want:     []string{"> 0208: 1208"},
+			pc:       0x208,
+			v:        map[int]byte{0: 1},
+		},
+		{
+			name:     "disassemble",
+			program:  callProgram,
+			commands: []string{"break 0x204", "dis 0x200 3"},
+			want:     []string{" > 0200: 6005", "   0202: 220C", "*  0204: 220C"},
+			pc:       0x200,
+		},
+		{
+			name:     "back",
+			program:  callProgram,
+			commands: []string{"step 4", "back 2"},
+			want:     []string{"> 0210:", "> 020C:"},
+			pc:       0x20C,
+			v:        map[int]byte{0: 5, 1: 0, 2: 0},
+		},
+		{
+			name:     "errors",
+			program:  callProgram,
+			commands: []string{"frobnicate", "break", "break 0x200 when V0", "x", "set V0 0x100", "step 0x", "back 0"},
+			want: []string{
+				"err: unknown command 'frobnicate', try 'help'",
+				"err: usage: break ADDR [if EXPR]",
+				"err: usage: break ADDR [if EXPR]",
+				"err: usage: x ADDR [N]",
+				"err: invalid value '0x100'",
+				"err: invalid value '0x'",
+				"err: invalid count '0'",
+			},
+			pc: 0x200,
+		},
+		{
+			name:     "quit",
+			program:  callProgram,
+			commands: []string{"quit", "step"},
+			want:     []string{"(debug) "},
+			pc:       0x200,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vm, out := runDebugger(t, tc.program, tc.commands...)
+			checkOutput(t, out, tc.want)
+			regs := vm.Registers()
+			if regs.PC != tc.pc {
+				t.Errorf("PC = 0x%03X, want 0x%03X", regs.PC, tc.pc)
+			}
+			for idx, want := range tc.v {
+				if regs.V[idx] != want {
+					t.Errorf("V%X = 0x%02X, want 0x%02X", idx, regs.V[idx], want)
+				}
+			}
+		})
+	}
+}
+
+// checkOutput checks that the wanted strings appear in order in out.
+func checkOutput(t *testing.T, out string, want []string) {
+	t.Helper()
+	rest := out
+	for _, s := range want {
+		idx := strings.Index(rest, s)
+		if idx < 0 {
+			t.Errorf("output does not contain %q after the previous matches, output:\n%s", s, out)
+			return
+		}
+		rest = rest[idx+len(s):]
+	}
+}
@@ -0,0 +1,142 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrorKind identifies what went wrong in a RuntimeError.
+type ErrorKind int
+
+const (
+	// CALL with 15 return addresses on the stack, the first of its 16
+	// entries being unused
+	StackOverflow ErrorKind = 1 + iota
+	// RET with an empty stack
+	StackUnderflow
+	// Access to an address past the end of memory
+	MemoryOutOfBounds
+	// EX9E or EXA1 with Vx above 0xF
+	InvalidKey
+	// An opcode that does not decode, including SYS calls
+	UnknownOpcode
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case StackOverflow: return "stack overflow"
+	case StackUnderflow: return "stack underflow"
+	case MemoryOutOfBounds: return "memory access out of bounds"
+	case InvalidKey: return "invalid key"
+	case UnknownOpcode: return "unknown opcode"
+	}
+	return fmt.Sprintf("error %d", int(k))
+}
+
+// RuntimeError is an error an instruction ran into, with the address and
+// opcode of the instruction.
+type RuntimeError struct {
+	Kind ErrorKind
+	PC uint16
+	Opcode uint16
+	// The address accessed or the key checked, if any
+	Value int
+}
+
+func (e *RuntimeError) Error() string {
+	msg := fmt.Sprintf("%s at 0x%03X (opcode %04X)", e.Kind, e.PC, e.Opcode)
+	switch e.Kind {
+	case MemoryOutOfBounds: return fmt.Sprintf("%s: address 0x%X", msg, e.Value)
+	case InvalidKey: return fmt.Sprintf("%s: key 0x%02X", msg, e.Value)
+	}
+	return msg
+}
+
+// ErrorPolicy is what the VM does when an instruction runs into an error.
+type ErrorPolicy int
+
+const (
+	// Stop and return a RuntimeError
+	HaltOnError ErrorPolicy = iota
+	// Wrap addresses around memory, the stack pointer around the stack
+	// and keys to 0x0-0xF
+	WrapOnError
+	// Skip what failed: out of bounds bytes are not written and read as
+	// 0, a CALL with a full stack jumps without pushing, a RET with an
+	// empty stack does nothing, invalid keys are not pressed and an
+	// instruction straddling the end of memory ends the program
+	IgnoreErrors
+)
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case HaltOnError: return "halt"
+	case WrapOnError: return "wrap"
+	case IgnoreErrors: return "ignore"
+	}
+	return fmt.Sprintf("policy %d", int(p))
+}
+
+// ErrorPolicies maps the names of the policies to them.
+var ErrorPolicies = map[string]ErrorPolicy{
+	"halt": HaltOnError,
+	"wrap": WrapOnError,
+	"ignore": IgnoreErrors,
+}
+
+// ParseErrorPolicy returns the policy registered under name.
+func ParseErrorPolicy(name string) (ErrorPolicy, error) {
+	p, ok := ErrorPolicies[strings.ToLower(name)]
+	if !ok {
+		return HaltOnError, fmt.Errorf("unknown error policy '%s' (available: %s)", name, strings.Join(ErrorPolicyNames(), ", "))
+	}
+	return p, nil
+}
+
+// ErrorPolicyNames lists the available policies in a stable order.
+func ErrorPolicyNames() []string {
+	names := make([]string, 0, len(ErrorPolicies))
+	for name := range ErrorPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fault records an error of the current instruction, which execute
+// returns once it is done. It reports whether the instruction carries on,
+// which it does unless the policy is to halt.
+func (vm *VirtualMachine) fault(kind ErrorKind, value int) bool {
+	if vm.OnError != HaltOnError {
+		return true
+	}
+	if vm.runErr == nil {
+		vm.runErr = &RuntimeError{Kind: kind, PC: vm.instPC, Opcode: vm.readWord(vm.instPC), Value: value}
+	}
+	return false
+}
+
+// memoryAddress checks an address an instruction accesses, returning
+// the address to use and whether to access it.
+func (vm *VirtualMachine) memoryAddress(addr int) (int, bool) {
+	if addr >= 0 && addr < len(vm.memory) {
+		return addr, true
+	}
+	if !vm.fault(MemoryOutOfBounds, addr) || vm.OnError == IgnoreErrors {
+		return 0, false
+	}
+	return addr % len(vm.memory), true
+}
+
+// keyPressed reports whether the key in Vx is pressed.
+func (vm *VirtualMachine) keyPressed(x byte) bool {
+	key := vm.r[x]
+	if int(key) >= len(vm.keypad) {
+		if !vm.fault(InvalidKey, int(key)) || vm.OnError == IgnoreErrors {
+			return false
+		}
+		key &= 0xF
+	}
+	return vm.keypad[key]
+}
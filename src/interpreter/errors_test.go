@@ -0,0 +1,302 @@
+package interpreter_test
+
+import (
+	"errors"
+	"testing"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/input"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+// errorCase is a program run under one error policy.
+type errorCase struct {
+	policy interpreter.ErrorPolicy
+	cycles int
+	// Expected error, nil when the program should run through
+	err *interpreter.RuntimeError
+	end bool
+	// Checks the state left behind
+	check func(t *testing.T, vm *interpreter.VirtualMachine)
+}
+
+func checkPC(want uint16) func(*testing.T, *interpreter.VirtualMachine) {
+	return func(t *testing.T, vm *interpreter.VirtualMachine) {
+		t.Helper()
+		if pc := vm.Registers().PC; pc != want {
+			t.Errorf("PC = 0x%03X, want 0x%03X", pc, want)
+		}
+	}
+}
+
+func checkSP(want uint16, pc uint16) func(*testing.T, *interpreter.VirtualMachine) {
+	return func(t *testing.T, vm *interpreter.VirtualMachine) {
+		t.Helper()
+		if regs := vm.Registers(); regs.SP != want || regs.PC != pc {
+			t.Errorf("SP = %d and PC = 0x%03X, want %d and 0x%03X", regs.SP, regs.PC, want, pc)
+		}
+	}
+}
+
+func checkMemory(addr int, want ...byte) func(*testing.T, *interpreter.VirtualMachine) {
+	return func(t *testing.T, vm *interpreter.VirtualMachine) {
+		t.Helper()
+		got, err := vm.ReadMemory(addr, len(want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("memory at 0x%03X = % X, want % X", addr, got, want)
+		}
+	}
+}
+
+var errorTests = []struct {
+	name    string
+	program []byte
+	// Changes made to memory after loading the program
+	patch map[int][]byte
+	// Key held from the first frame on
+	key   int
+	cases []errorCase
+}{
+	{
+		name:    "stack overflow",
+		program: []byte{0x22, 0x00}, // 200: CALL 0x200
+		key:     -1,
+		cases: []errorCase{
+			{interpreter.HaltOnError, 16, &interpreter.RuntimeError{Kind: interpreter.StackOverflow, PC: 0x200, Opcode: 0x2200}, false, checkSP(15, 0x202)},
+			// The stack pointer wraps to the first entry
+			{interpreter.WrapOnError, 16, nil, false, checkSP(1, 0x200)},
+			// The call jumps without pushing
+			{interpreter.IgnoreErrors, 16, nil, false, checkSP(15, 0x200)},
+		},
+	},
+	{
+		name:    "stack underflow",
+		program: []byte{0x00, 0xEE}, // 200: RET
+		key:     -1,
+		cases: []errorCase{
+			{interpreter.HaltOnError, 1, &interpreter.RuntimeError{Kind: interpreter.StackUnderflow, PC: 0x200, Opcode: 0x00EE}, false, checkSP(0, 0x202)},
+			// Returns to the unused last entry of the stack
+			{interpreter.WrapOnError, 1, nil, false, checkSP(14, 0x000)},
+			{interpreter.IgnoreErrors, 1, nil, false, checkSP(0, 0x202)},
+		},
+	},
+	{
+		name: "store out of bounds",
+		program: []byte{
+			0xAF, 0xFF, // 200: LD I, 0xFFF
+			0x60, 0xAA, // 202: LD V0, 0xAA
+			0x61, 0xBB, // 204: LD V1, 0xBB
+			0xF1, 0x55, // 206: LD [I], V1
+		},
+		key: -1,
+		cases: []errorCase{
+			// The bytes in bounds are written
+			{interpreter.HaltOnError, 4, &interpreter.RuntimeError{Kind: interpreter.MemoryOutOfBounds, PC: 0x206, Opcode: 0xF155, Value: 0x1000}, false, func(t *testing.T, vm *interpreter.VirtualMachine) {
+				checkMemory(0xFFF, 0xAA)(t, vm)
+				checkMemory(0x000, 0xF0)(t, vm)
+			}},
+			{interpreter.WrapOnError, 4, nil, false, func(t *testing.T, vm *interpreter.VirtualMachine) {
+				checkMemory(0xFFF, 0xAA)(t, vm)
+				checkMemory(0x000, 0xBB)(t, vm)
+			}},
+			{interpreter.IgnoreErrors, 4, nil, false, func(t *testing.T, vm *interpreter.VirtualMachine) {
+				checkMemory(0xFFF, 0xAA)(t, vm)
+				checkMemory(0x000, 0xF0)(t, vm)
+			}},
+		},
+	},
+	{
+		name: "load out of bounds",
+		program: []byte{
+			0xAF, 0xFF, // 200: LD I, 0xFFF
+			0x60, 0x11, // 202: LD V0, 0x11
+			0x61, 0x22, // 204: LD V1, 0x22
+			0xF1, 0x65, // 206: LD V1, [I]
+		},
+		patch: map[int][]byte{0xFFF: {0x33}},
+		key:   -1,
+		cases: []errorCase{
+			{interpreter.HaltOnError, 4, &interpreter.RuntimeError{Kind: interpreter.MemoryOutOfBounds, PC: 0x206, Opcode: 0xF165, Value: 0x1000}, false, nil},
+			{interpreter.WrapOnError, 4, nil, false, func(t *testing.T, vm *interpreter.VirtualMachine) {
+				if v := vm.Registers().V; v[0] != 0x33 || v[1] != 0xF0 {
+					t.Errorf("V0, V1 = %02X, %02X, want 33, F0 read from 0xFFF and 0x000", v[0], v[1])
+				}
+			}},
+			// Out of bounds bytes read as 0
+			{interpreter.IgnoreErrors, 4, nil, false, func(t *testing.T, vm *interpreter.VirtualMachine) {
+				if v := vm.Registers().V; v[0] != 0x33 || v[1] != 0x00 {
+					t.Errorf("V0, V1 = %02X, %02X, want 33, 00", v[0], v[1])
+				}
+			}},
+		},
+	},
+	{
+		name: "sprite out of bounds",
+		program: []byte{
+			0xAF, 0xFF, // 200: LD I, 0xFFF
+			0xD0, 0x02, // 202: DRW V0, V0, 2
+		},
+		patch: map[int][]byte{0xFFF: {0x80}},
+		key:   -1,
+		cases: []errorCase{
+			{interpreter.HaltOnError, 2, &interpreter.RuntimeError{Kind: interpreter.MemoryOutOfBounds, PC: 0x202, Opcode: 0xD002, Value: 0x1000}, false, nil},
+			{interpreter.WrapOnError, 2, nil, false, checkPC(0x204)},
+			{interpreter.IgnoreErrors, 2, nil, false, checkPC(0x204)},
+		},
+	},
+	{
+		name: "invalid key",
+		program: []byte{
+			0x60, 0x10, // 200: LD V0, 0x10
+			0xE0, 0x9E, // 202: SKP V0
+		},
+		key: 0,
+		cases: []errorCase{
+			{interpreter.HaltOnError, 2, &interpreter.RuntimeError{Kind: interpreter.InvalidKey, PC: 0x202, Opcode: 0xE09E, Value: 0x10}, false, nil},
+			// Key 0x10 is key 0, which is held
+			{interpreter.WrapOnError, 2, nil, false, checkPC(0x206)},
+			// Invalid keys are never pressed
+			{interpreter.IgnoreErrors, 2, nil, false, checkPC(0x204)},
+		},
+	},
+	{
+		name:    "unknown opcode",
+		program: []byte{0x01, 0x23}, // 200: SYS 0x123
+		key:     -1,
+		cases: []errorCase{
+			{interpreter.HaltOnError, 1, &interpreter.RuntimeError{Kind: interpreter.UnknownOpcode, PC: 0x200, Opcode: 0x0123}, false, checkPC(0x202)},
+			{interpreter.WrapOnError, 1, nil, false, checkPC(0x202)},
+			{interpreter.IgnoreErrors, 1, nil, false, checkPC(0x202)},
+		},
+	},
+	{
+		name:    "instruction straddling the end",
+		program: []byte{0x1F, 0xFF}, // 200: JP 0xFFF
+		patch:   map[int][]byte{0xFFF: {0x60}},
+		key:     -1,
+		cases: []errorCase{
+			{interpreter.HaltOnError, 2, &interpreter.RuntimeError{Kind: interpreter.MemoryOutOfBounds, PC: 0xFFF, Opcode: 0x60F0, Value: 0x1000}, false, nil},
+			// Reads 60F0 wrapping around, LD V0, 0xF0
+			{interpreter.WrapOnError, 2, nil, false, func(t *testing.T, vm *interpreter.VirtualMachine) {
+				if v0 := vm.Registers().V[0]; v0 != 0xF0 {
+					t.Errorf("V0 = %02X, want F0", v0)
+				}
+			}},
+			{interpreter.IgnoreErrors, 2, nil, true, nil},
+		},
+	},
+	{
+		name:    "running off the end",
+		program: []byte{0x1F, 0xFE}, // 200: JP 0xFFE
+		patch:   map[int][]byte{0xFFE: {0x60, 0x01}},
+		key:     -1,
+		cases: []errorCase{
+			// Ends cleanly, as in earlier versions
+			{interpreter.HaltOnError, 3, nil, true, checkPC(0x1000)},
+			// Continues at 0x000 with the font
+			{interpreter.WrapOnError, 3, nil, false, checkPC(0x002)},
+			{interpreter.IgnoreErrors, 3, nil, true, checkPC(0x1000)},
+		},
+	},
+}
+
+func TestErrorPolicies(t *testing.T) {
+	names := map[interpreter.ErrorPolicy]string{interpreter.HaltOnError: "halt", interpreter.WrapOnError: "wrap", interpreter.IgnoreErrors: "ignore"}
+	for _, test := range errorTests {
+		for _, tc := range test.cases {
+			t.Run(test.name+"/"+names[tc.policy], func(t *testing.T) {
+				vm := &interpreter.VirtualMachine{
+					Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+					OnError: tc.policy,
+				}
+				if test.key >= 0 {
+					script := input.NewScript()
+					script.Press(byte(test.key))
+					vm.Input = script
+				}
+				// One instruction per frame, so that the keypad is read
+				// after the first one
+				if err := vm.Init(test.program, 60); err != nil {
+					t.Fatal(err)
+				}
+				for addr, data := range test.patch {
+					if err := vm.WriteMemory(addr, data); err != nil {
+						t.Fatal(err)
+					}
+				}
+				end, err := vm.RunCycles(tc.cycles)
+				if tc.err == nil {
+					if err != nil {
+						t.Fatalf("got error %v, want none", err)
+					}
+				} else {
+					var runErr *interpreter.RuntimeError
+					if !errors.As(err, &runErr) {
+						t.Fatalf("got error %v, want %v", err, tc.err)
+					}
+					if *runErr != *tc.err {
+						t.Errorf("got error %+v, want %+v", *runErr, *tc.err)
+					}
+				}
+				if end != tc.end {
+					t.Errorf("end = %v, want %v", end, tc.end)
+				}
+				if tc.check != nil {
+					tc.check(t, vm)
+				}
+			})
+		}
+	}
+}
+
+func TestRuntimeErrorMessages(t *testing.T) {
+	tests := []struct {
+		err  interpreter.RuntimeError
+		want string
+	}{
+		{interpreter.RuntimeError{Kind: interpreter.StackOverflow, PC: 0x200, Opcode: 0x2200}, "stack overflow at 0x200 (opcode 2200)"},
+		{interpreter.RuntimeError{Kind: interpreter.StackUnderflow, PC: 0x20A, Opcode: 0x00EE}, "stack underflow at 0x20A (opcode 00EE)"},
+		{interpreter.RuntimeError{Kind: interpreter.MemoryOutOfBounds, PC: 0x206, Opcode: 0xF155, Value: 0x1000}, "memory access out of bounds at 0x206 (opcode F155): address 0x1000"},
+		{interpreter.RuntimeError{Kind: interpreter.InvalidKey, PC: 0x202, Opcode: 0xE09E, Value: 0x10}, "invalid key at 0x202 (opcode E09E): key 0x10"},
+		{interpreter.RuntimeError{Kind: interpreter.UnknownOpcode, PC: 0x200, Opcode: 0x0123}, "unknown opcode at 0x200 (opcode 0123)"},
+	}
+	for _, tc := range tests {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+	for _, name := range []string{"halt", "WRAP", "ignore"} {
+		if _, err := interpreter.ParseErrorPolicy(name); err != nil {
+			t.Errorf("ParseErrorPolicy(%q): %v", name, err)
+		}
+	}
+	if _, err := interpreter.ParseErrorPolicy("crash"); err == nil || err.Error() != "unknown error policy 'crash' (available: halt, ignore, wrap)" {
+		t.Errorf("ParseErrorPolicy(\"crash\") = %v", err)
+	}
+}
+
+func TestInitProgramTooLarge(t *testing.T) {
+	tests := []struct {
+		memoryBytes int
+		size        int
+		err         string
+	}{
+		{memoryBytes: 0, size: 3584},
+		{memoryBytes: 0, size: 3585, err: "program of 3585 bytes does not fit in memory, at most 3584 bytes can be loaded"},
+		{memoryBytes: interpreter.XOChipMemorySize, size: 3585},
+		{memoryBytes: interpreter.XOChipMemorySize, size: 65025, err: "program of 65025 bytes does not fit in memory, at most 65024 bytes can be loaded"},
+	}
+	for _, tc := range tests {
+		vm := &interpreter.VirtualMachine{
+			Display:     &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+			MemoryBytes: tc.memoryBytes,
+		}
+		err := vm.Init(make([]byte, tc.size), 700)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("Init of %d bytes with %d bytes of memory = %v, want %q", tc.size, tc.memoryBytes, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package interpreter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a compiled debugger expression such as "V3 == 0x10 && I > 0x300".
+// Operands are numbers, the registers V0-VF, I, PC, SP, DT and ST, and
+// [addr] for the byte at an address. Operators follow C precedence.
+type expr func(vm *VirtualMachine) int
+
+// Binary operators by precedence level, loosest first
+var exprLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+var exprOps = map[string]func(a, b int) int{
+	"||": func(a, b int) int { return exprBool(a != 0 || b != 0) },
+	"&&": func(a, b int) int { return exprBool(a != 0 && b != 0) },
+	"|": func(a, b int) int { return a | b },
+	"^": func(a, b int) int { return a ^ b },
+	"&": func(a, b int) int { return a & b },
+	"==": func(a, b int) int { return exprBool(a == b) },
+	"!=": func(a, b int) int { return exprBool(a != b) },
+	"<=": func(a, b int) int { return exprBool(a <= b) },
+	">=": func(a, b int) int { return exprBool(a >= b) },
+	"<": func(a, b int) int { return exprBool(a < b) },
+	">": func(a, b int) int { return exprBool(a > b) },
+	"<<": func(a, b int) int { return a << uint(b & 0x3F) },
+	">>": func(a, b int) int { return a >> uint(b & 0x3F) },
+	"+": func(a, b int) int { return a + b },
+	"-": func(a, b int) int { return a - b },
+	"*": func(a, b int) int { return a * b },
+	"/": func(a, b int) int {
+		if b == 0 {
+			return 0
+		}
+		return a / b
+	},
+	"%": func(a, b int) int {
+		if b == 0 {
+			return 0
+		}
+		return a % b
+	},
+}
+
+func exprBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseExpr compiles an expression.
+func parseExpr(s string) (expr, error) {
+	tokens, err := exprTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	e, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(tokens) {
+		return nil, fmt.Errorf("unexpected '%s' in expression", tokens[p.pos])
+	}
+	return e, nil
+}
+
+func exprTokens(s string) ([]string, error) {
+	var tokens []string
+	for idx := 0; idx < len(s); {
+		c := rune(s[idx])
+		switch {
+		case unicode.IsSpace(c):
+			idx++
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_':
+			end := idx
+			for end < len(s) && (unicode.IsLetter(rune(s[end])) || unicode.IsDigit(rune(s[end])) || s[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, s[idx:end])
+			idx = end
+		case idx + 1 < len(s) && exprOps[s[idx:idx + 2]] != nil:
+			tokens = append(tokens, s[idx:idx + 2])
+			idx += 2
+		case strings.ContainsRune("|^&<>+-*/%!~()[]", c):
+			tokens = append(tokens, string(c))
+			idx++
+		default:
+			return nil, fmt.Errorf("unexpected '%c' in expression", c)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("incomplete expression")
+	}
+	p.pos++
+	return p.tokens[p.pos - 1], nil
+}
+
+// binary parses operators of the given precedence level and tighter.
+func (p *exprParser) binary(level int) (expr, error) {
+	if level == len(exprLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		matched := false
+		for _, candidate := range exprLevels[level] {
+			if op == candidate {
+				matched = true
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		apply, l := exprOps[op], left
+		left = func(vm *VirtualMachine) int { return apply(l(vm), right(vm)) }
+	}
+}
+
+func (p *exprParser) unary() (expr, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case "!", "-", "~":
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case "!": return func(vm *VirtualMachine) int { return exprBool(operand(vm) == 0) }, nil
+		case "-": return func(vm *VirtualMachine) int { return -operand(vm) }, nil
+		}
+		return func(vm *VirtualMachine) int { return ^operand(vm) }, nil
+	case "(", "[":
+		inner, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		closing := map[string]string{"(": ")", "[": "]"}[t]
+		if end, err := p.next(); err != nil || end != closing {
+			return nil, fmt.Errorf("expected '%s'", closing)
+		}
+		if t == "(" {
+			return inner, nil
+		}
+		return func(vm *VirtualMachine) int {
+			size := len(vm.memory)
+			return int(vm.memory[(inner(vm) % size + size) % size])
+		}, nil
+	}
+	if value, err := parseNumber(t, 63); err == nil {
+		return func(*VirtualMachine) int { return int(value) }, nil
+	}
+	return registerExpr(t)
+}
+
+func registerExpr(name string) (expr, error) {
+	upper := strings.ToUpper(name)
+	if len(upper) == 2 && upper[0] == 'V' {
+		if x, err := strconv.ParseUint(upper[1:], 16, 8); err == nil {
+			return func(vm *VirtualMachine) int { return int(vm.r[x]) }, nil
+		}
+	}
+	switch upper {
+	case "I": return func(vm *VirtualMachine) int { return int(vm.i) }, nil
+	case "PC": return func(vm *VirtualMachine) int { return int(vm.pc) }, nil
+	case "SP": return func(vm *VirtualMachine) int { return int(vm.sp) }, nil
+	case "DT": return func(vm *VirtualMachine) int { return int(vm.dt) }, nil
+	case "ST": return func(vm *VirtualMachine) int { return int(vm.ds) }, nil
+	}
+	return nil, fmt.Errorf("unknown name '%s' in expression", name)
+}
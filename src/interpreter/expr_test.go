@@ -0,0 +1,102 @@
+package interpreter
+
+import (
+	"testing"
+
+	disp "github.com/abhinand20/emugo/display"
+)
+
+func exprVM() *VirtualMachine {
+	vm := &VirtualMachine{Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth}}
+	vm.Init(nil, 700)
+	vm.r[3] = 0x10
+	vm.r[0xF] = 1
+	vm.i = 0x300
+	vm.memory[0x300] = 9
+	vm.memory[0x301] = 0x20
+	vm.dt = 5
+	return vm
+}
+
+func TestExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"42", 42},
+		{"0x2A", 42},
+		{"0X2a", 42},
+		// Not octal
+		{"010", 10},
+		// Precedence
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"1 << 2 + 1", 8},
+		{"8 - 2 - 1", 5},
+		{"16 / 4 / 2", 2},
+		{"1 | 6 & 3", 3},
+		{"1 || 0 && 0", 1},
+		{"2 < 3 == 1", 1},
+		{"5 ^ 1 == 1", 4},
+		{"-2 * 3", -6},
+		{"!0 + 1", 2},
+		{"~0", -1},
+		// Division by zero gives 0 rather than failing the watch
+		{"10 / 0", 0},
+		{"10 % 0", 0},
+		{"10 % 3", 1},
+		// Registers
+		{"V3", 0x10},
+		{"vf", 1},
+		{"V3 == 0x10 && I > 0x2FF", 1},
+		{"V3 == 0x10 && I > 0x300", 0},
+		{"PC", 0x200},
+		{"SP", 0},
+		{"DT + ST", 5},
+		// Memory
+		{"[0x300]", 9},
+		{"[I]", 9},
+		{"[I + 1]", 0x20},
+		{"[I] * 2 + [0x301]", 0x32},
+		{"[0x300 + 0x1000]", 9},
+	}
+	vm := exprVM()
+	for _, tc := range tests {
+		e, err := parseExpr(tc.expr)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := e(vm); got != tc.want {
+			t.Errorf("%q = %d, want %d", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "incomplete expression"},
+		{"V3 ==", "incomplete expression"},
+		{"(1 + 2", "expected ')'"},
+		{"[0x300", "expected ']'"},
+		{"1 2", "unexpected '2' in expression"},
+		{"1 $ 2", "unexpected '$' in expression"},
+		{"VG", "unknown name 'VG' in expression"},
+		{"X + 1", "unknown name 'X' in expression"},
+		{"0b1", "unknown name '0b1' in expression"},
+		{"1_000", "unknown name '1_000' in expression"},
+	}
+	for _, tc := range tests {
+		_, err := parseExpr(tc.expr)
+		if err == nil {
+			t.Errorf("parseExpr(%q) succeeded, want %q", tc.expr, tc.want)
+			continue
+		}
+		if err.Error() != tc.want {
+			t.Errorf("parseExpr(%q) = %q, want %q", tc.expr, err, tc.want)
+		}
+	}
+}
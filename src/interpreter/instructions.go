@@ -2,26 +2,81 @@ package interpreter
 
 import (
 	common "github.com/abhinand20/emugo/common"
+	disp "github.com/abhinand20/emugo/display"
 	"github.com/abhinand20/emugo/input"
 )
 
 // OPCODE: 0xE0
 func (vm *VirtualMachine) _CLS() {
 	vm.Display.Clear()
+	vm.displayDirty = true
 }
 
 // OPCODE: 00EE
 func (vm *VirtualMachine) _RET() {
-	vm.pc = vm.stack[vm.sp]
-	if vm.sp != 0 {
-		vm.sp -= 1
+	if vm.sp == 0 {
+		if !vm.fault(StackUnderflow, 0) || vm.OnError == IgnoreErrors {
+			return
+		}
+		vm.sp = uint16(len(vm.stack) - 1)
 	}
+	vm.pc = vm.stack[vm.sp]
+	vm.sp -= 1
+}
+
+// OPCODE: 00Cn
+func (vm *VirtualMachine) _SCD(n byte) {
+	vm.Display.ScrollDown(uint32(n))
+	vm.displayDirty = true
+}
+
+// OPCODE: 00Dn
+func (vm *VirtualMachine) _SCU(n byte) {
+	vm.Display.ScrollUp(uint32(n))
+	vm.displayDirty = true
+}
+
+// OPCODE: 00FB
+func (vm *VirtualMachine) _SCR() {
+	vm.Display.ScrollRight(4)
+	vm.displayDirty = true
+}
+
+// OPCODE: 00FC
+func (vm *VirtualMachine) _SCL() {
+	vm.Display.ScrollLeft(4)
+	vm.displayDirty = true
+}
+
+// OPCODE: 00FD
+func (vm *VirtualMachine) _EXIT() {
+	vm.halted = true
+}
+
+// OPCODE: 00FE
+func (vm *VirtualMachine) _LOW() {
+	vm.Display.SetResolution(disp.LoresWidth, disp.LoresHeight)
+	vm.displayDirty = true
+}
+
+// OPCODE: 00FF
+func (vm *VirtualMachine) _HIGH() {
+	vm.Display.SetResolution(disp.HiresWidth, disp.HiresHeight)
+	vm.displayDirty = true
 }
 
 // OPCODE: 2nnn
 func (vm *VirtualMachine) _CALL(addr uint16) {
-	if vm.sp >= uint16(len(vm.stack)) {
-		panic("[Stack Overflow] Cannot execute instruction.")
+	// stack[0] is never used, so the stack holds 15 return addresses
+	if int(vm.sp) + 1 >= len(vm.stack) {
+		if !vm.fault(StackOverflow, 0) {
+			return
+		}
+		if vm.OnError == IgnoreErrors {
+			vm.pc = addr
+			return
+		}
+		vm.sp = 0
 	}
 	vm.sp++
 	vm.stack[vm.sp] = vm.pc
@@ -36,29 +91,43 @@ func (vm *VirtualMachine) _JP(addr uint16) {
 // OPCODE: 3xkk
 func (vm *VirtualMachine) _SEVal(x, kk byte) {
 	if vm.r[x] == kk {
-		vm.pc += 2
+		vm.skip()
 	}
 }
 
 // OPCODE: 4xkk
 func (vm *VirtualMachine) _SNEVal(x, kk byte) {
 	if vm.r[x] != kk {
-		vm.pc += 2
+		vm.skip()
 	}
 }
 
 // OPCODE: 5xy0
 func (vm *VirtualMachine) _SE(x, y byte) {
 	if vm.r[x] == vm.r[y] {
-		vm.pc += 2
+		vm.skip()
 	}
 }
 
 
+// OPCODE: 5xy2
+func (vm *VirtualMachine) _SAVERANGE(x, y byte) {
+	for idx, reg := range registerRange(x, y) {
+		vm.writeMemory(int(vm.i) + idx, vm.r[reg])
+	}
+}
+
+// OPCODE: 5xy3
+func (vm *VirtualMachine) _LOADRANGE(x, y byte) {
+	for idx, reg := range registerRange(x, y) {
+		vm.r[reg] = vm.readMemory(int(vm.i) + idx)
+	}
+}
+
 // OPCODE: 9xy0
 func (vm *VirtualMachine) _SNE(x, y byte) {
 	if vm.r[x] != vm.r[y] {
-		vm.pc += 2
+		vm.skip()
 	}
 }
 
@@ -74,16 +143,25 @@ func (vm *VirtualMachine) _LD(x, y byte) {
 // OPCODE: 8xy1
 func (vm *VirtualMachine) _OR(x, y byte) {
 	vm.r[x] |= vm.r[y]
+	if vm.Quirks.LogicResetsVF {
+		vm.resetVF()
+	}
 }
 
 // OPCODE: 8xy2
 func (vm *VirtualMachine) _AND(x, y byte) {
 	vm.r[x] &= vm.r[y]
+	if vm.Quirks.LogicResetsVF {
+		vm.resetVF()
+	}
 }
 
 // OPCODE: 8xy3
 func (vm *VirtualMachine) _XOR(x, y byte) {
 	vm.r[x] ^= vm.r[y]
+	if vm.Quirks.LogicResetsVF {
+		vm.resetVF()
+	}
 }
 
 // OPCODE: 8xy4
@@ -111,7 +189,10 @@ func (vm *VirtualMachine) _SUB(x, y byte) {
 }
 
 // OPCODE: 8xy6
-func (vm *VirtualMachine) _SHR(x byte) {
+func (vm *VirtualMachine) _SHR(x, y byte) {
+	if vm.Quirks.ShiftUsesVy {
+		vm.r[x] = vm.r[y]
+	}
 	vx := vm.r[x]
 	vm.r[x] >>= 1
 	vm.resetVF()
@@ -133,7 +214,10 @@ func (vm *VirtualMachine) _SUBN(x, y byte) {
 }
 
 // OPCODE: 8xyE
-func (vm *VirtualMachine) _SHL(x byte) {
+func (vm *VirtualMachine) _SHL(x, y byte) {
+	if vm.Quirks.ShiftUsesVy {
+		vm.r[x] = vm.r[y]
+	}
 	vx := vm.r[x]
 	vm.r[x] <<= 1
 	vm.resetVF()
@@ -146,25 +230,31 @@ func (vm *VirtualMachine) _SHL(x byte) {
 func (vm *VirtualMachine) _LDR(x byte) {
 	readAddr := vm.i
 	for idx := byte(0); idx <= x; idx++ {
-		vm.r[idx] = vm.memory[readAddr]
+		vm.r[idx] = vm.readMemory(int(readAddr))
 		readAddr++
 	}
+	if vm.Quirks.LoadStoreIncrementsI {
+		vm.i = readAddr
+	}
 }
 
 // OPCODE: Fx55
 func (vm *VirtualMachine) _STR(x byte) {
 	storeAddr := vm.i
 	for idx := byte(0); idx <= x; idx++ {
-		vm.memory[storeAddr] = vm.r[idx]
+		vm.writeMemory(int(storeAddr), vm.r[idx])
 		storeAddr++
 	}
+	if vm.Quirks.LoadStoreIncrementsI {
+		vm.i = storeAddr
+	}
 }
 
 // OPCODE: Fx33
 func (vm *VirtualMachine) _LDBCD(x byte) {
-	vm.memory[vm.i] = vm.r[x] / 100
-	vm.memory[vm.i + 1] = (vm.r[x] / 10) % 10
-	vm.memory[vm.i + 2] = vm.r[x] % 10
+	vm.writeMemory(int(vm.i), vm.r[x] / 100)
+	vm.writeMemory(int(vm.i) + 1, (vm.r[x] / 10) % 10)
+	vm.writeMemory(int(vm.i) + 2, vm.r[x] % 10)
 }
 
 
@@ -187,8 +277,16 @@ func (vm *VirtualMachine) _LDI(addr uint16) {
 func (vm *VirtualMachine) _DRW(x, y, n byte) {
 	vx := vm.r[x]
 	vy := vm.r[y]
-	collision := vm.Display.UpdateState(&vm.memory, vm.i, vx, vy, n)
-	vm.Display.Render()
+	// Sprites reaching past the end of memory wrap around unless halting
+	if last := int(vm.i) + vm.spriteBytes(n) - 1; last >= len(vm.memory) && !vm.fault(MemoryOutOfBounds, last) {
+		return
+	}
+	vm.noteSpriteRead(n)
+	collision := vm.Display.UpdateState(vm.memory, vm.i, vx, vy, n, vm.Quirks.ClipSprites)
+	vm.displayDirty = true
+	if vm.Quirks.DisplayWait {
+		vm.waitVBlank = true
+	}
 	vm.resetVF()
 	if collision {
 		vm.setVF()
@@ -223,30 +321,80 @@ func (vm *VirtualMachine) _STRDT(x byte) {
 
 // OPCODE: Ex9E
 func (vm *VirtualMachine) _SKP(x byte) {
-	if vm.keypad[vm.r[x]] {
-		vm.pc += 2
+	if vm.keyPressed(x) {
+		vm.skip()
 	}
 }
 
 // OPCODE: ExA1
 func (vm *VirtualMachine) _SKPN(x byte) {
-	if !vm.keypad[vm.r[x]] {
-		vm.pc += 2
+	if !vm.keyPressed(x) {
+		vm.skip()
 	}
 }
 
 // OPCODE: Fx29
 func (vm *VirtualMachine) _LDSPRITE(x byte) {
-	vm.i = uint16(common.SpriteStartOffsetBytes + vm.r[x])
+	vm.i = uint16(common.SpriteStartOffsetBytes) + uint16(vm.r[x] & 0xF) * 5
+}
+
+// OPCODE: Fx30
+func (vm *VirtualMachine) _LDBIGSPRITE(x byte) {
+	vm.i = uint16(common.BigSpriteStartOffsetBytes) + uint16(vm.r[x] & 0xF) * 10
+}
+
+// OPCODE: Fx75
+func (vm *VirtualMachine) _STRRPL(x byte) {
+	for idx := byte(0); idx <= x; idx++ {
+		vm.rpl[idx] = vm.r[idx]
+	}
+}
+
+// OPCODE: Fx85
+func (vm *VirtualMachine) _LDRPL(x byte) {
+	for idx := byte(0); idx <= x; idx++ {
+		vm.r[idx] = vm.rpl[idx]
+	}
+}
+
+// OPCODE: F000 nnnn
+func (vm *VirtualMachine) _LDILONG() {
+	if int(vm.pc) + 1 >= len(vm.memory) && !vm.fault(MemoryOutOfBounds, int(vm.pc) + 1) {
+		return
+	}
+	vm.i = vm.readWord(vm.pc)
+	vm.pc += 2
+}
+
+// OPCODE: Fn01
+func (vm *VirtualMachine) _PLANE(n byte) {
+	vm.Display.SetPlanes(n)
+	vm.planes = n & 0x3
+}
+
+// OPCODE: F002
+func (vm *VirtualMachine) _AUDIO() {
+	for idx := range vm.audioPattern {
+		vm.audioPattern[idx] = vm.readMemory(int(vm.i) + idx)
+	}
+}
+
+// OPCODE: Fx3A
+func (vm *VirtualMachine) _PITCH(x byte) {
+	vm.pitch = vm.r[x]
 }
 
 // OPCODE: Cxnn
 func (vm *VirtualMachine) _RNG(x, nn byte) {
-	randByte := byte(vm.rng.Intn(256))
+	randByte := vm.random()
 	vm.r[x] = randByte & nn
 }
 
 // OPCODE: Bnnn
 func (vm *VirtualMachine) _JPAddr(nnn uint16) {
-	vm.pc = uint16(vm.r[0]) + nnn
+	var x uint16 = 0
+	if vm.Quirks.JumpUsesVx {
+		x = nnn >> 8
+	}
+	vm.pc = uint16(vm.r[x]) + nnn
 }
\ No newline at end of file
@@ -1,12 +1,9 @@
 package interpreter
 
 import (
-	"bufio"
-	"encoding/binary"
 	"fmt"
-	"math/rand"
+	"math/bits"
 	"os"
-	"strings"
 	"time"
 
 	common "github.com/abhinand20/emugo/common"
@@ -33,98 +30,289 @@ var spriteData = []byte{
 	0xF0, 0x80, 0xF0, 0x80, 0x80, // F
 }
 
+// SCHIP 8x10 font, stored right after the regular one
+var bigSpriteData = []byte{
+	0x3C, 0x7E, 0xE7, 0xC3, 0xC3, 0xC3, 0xC3, 0xE7, 0x7E, 0x3C, // 0
+	0x18, 0x38, 0x58, 0x18, 0x18, 0x18, 0x18, 0x18, 0x18, 0x3C, // 1
+	0x3E, 0x7F, 0xC3, 0x06, 0x0C, 0x18, 0x30, 0x60, 0xFF, 0xFF, // 2
+	0x3C, 0x7E, 0xC3, 0x03, 0x0E, 0x0E, 0x03, 0xC3, 0x7E, 0x3C, // 3
+	0x06, 0x0E, 0x1E, 0x36, 0x66, 0xC6, 0xFF, 0xFF, 0x06, 0x06, // 4
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFE, 0x03, 0xC3, 0x7E, 0x3C, // 5
+	0x3E, 0x7C, 0xC0, 0xC0, 0xFC, 0xFE, 0xC3, 0xC3, 0x7E, 0x3C, // 6
+	0xFF, 0xFF, 0x03, 0x06, 0x0C, 0x18, 0x30, 0x60, 0x60, 0x60, // 7
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7E, 0x7E, 0xC3, 0xC3, 0x7E, 0x3C, // 8
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7F, 0x3F, 0x03, 0x03, 0x3E, 0x7C, // 9
+	0x18, 0x3C, 0x66, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xC3, // A
+	0xFC, 0xFE, 0xC3, 0xC3, 0xFE, 0xFE, 0xC3, 0xC3, 0xFE, 0xFC, // B
+	0x3C, 0x7E, 0xC3, 0xC0, 0xC0, 0xC0, 0xC0, 0xC3, 0x7E, 0x3C, // C
+	0xFC, 0xFE, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFE, 0xFC, // D
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFC, 0xC0, 0xC0, 0xFF, 0xFF, // E
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFC, 0xC0, 0xC0, 0xC0, 0xC0, // F
+}
+
+// The VM executes in frames at this rate. Timers count down, input
+// is polled and the display is presented once per frame.
+const frameRate = 60
+
 // VirtualMachine loads up the program into memory
 // and executes it
 type VirtualMachine struct {
-	memory [4096]byte
+	memory []byte
 	Display disp.Display
 	pc uint16
 	i uint16
 	dt uint8
 	ds uint8
 	r [16]uint8
-	Clk *time.Ticker
+	// Paces frames, each running cyclesPerFrame instructions
+	frameClk *time.Ticker
+	cyclesPerFrame int
+	// Instructions per second, and the part of them left over by the
+	// frames so far, which adds an instruction to a frame once a whole
+	// one has built up
+	clockSpeed int
+	clockCarry int
+	// Instructions executed so far in the current frame
+	frameCycles int
 	sp uint16
 	stack [16]uint16
 	keypad [16]bool
-	Keyboard *input.Keyboard
+	// SCHIP RPL user flags
+	rpl [16]uint8
+	// Set by the SCHIP exit instruction
+	halted bool
+	// XO-CHIP audio pattern buffer and playback pitch
+	audioPattern [16]uint8
+	pitch uint8
+	// Selected XO-CHIP planes, to know how many bytes a draw reads
+	planes uint8
+	// Provides the keypad state each frame, such as the terminal keyboard
+	Input input.Source
+	// Actions bound to keys outside the keypad, run between frames
+	Hotkeys map[rune]func()
+	Quirks Quirks
+	// Size of the address space in bytes, 0 meaning DefaultMemorySize
+	MemoryBytes int
+	// What to do when an instruction runs into an error
+	OnError ErrorPolicy
+	// Address of the instruction being executed, and the first error it
+	// ran into when halting on errors
+	instPC uint16
+	runErr *RuntimeError
+	// Set when the display wait quirk ends the current frame early
+	waitVBlank bool
+	// Set when the display changed since it was last presented
+	displayDirty bool
 	/* States useful for debug mode */
 	Debug bool
-	rng *rand.Rand
+	// Console run before each instruction, created by Init when Debug is set
+	Debugger *Debugger
+	// Logs every executed instruction when set
+	Tracer *Tracer
+	// Snapshots taken at the start of each frame when set
+	Rewind *Rewind
+	// Instructions executed so far
+	cycles uint64
+	// Seed of the random number generator
+	Seed uint64
+	// State of the splitmix64 generator behind RND
+	rng uint64
+	// Records the keypad state of each frame when set
+	Recorder *MovieRecorder
 }
 
-func (vm *VirtualMachine) Init(program []byte, clkSpeed int) {
+// Init loads program at 0x200 and resets the VM to run it at clkSpeed
+// instructions per second. It fails if the program does not fit in memory.
+func (vm *VirtualMachine) Init(program []byte, clkSpeed int) error {
+	if limit := vm.memoryBytes() - common.ProgramStoreOffsetBytes; len(program) > limit {
+		return fmt.Errorf("program of %d bytes does not fit in memory, at most %d bytes can be loaded", len(program), limit)
+	}
+	vm.memory = make([]byte, vm.memoryBytes())
 	for idx := range program {
 		vm.memory[common.ProgramStoreOffsetBytes + idx] = program[idx]
 	}
 	vm.loadSpritesInMemory()
 	vm.pc = common.ProgramStoreOffsetBytes
 	vm.Display.Init()
-	vm.Clk = time.NewTicker(time.Second / time.Duration(clkSpeed))
-	vm.rng = rand.New(rand.NewSource(0))
+	vm.clockSpeed = clkSpeed
+	vm.clockCarry = 0
+	vm.nextFrameBudget()
+	vm.planes = 1
+	vm.rng = vm.Seed
+	if vm.Debug && vm.Debugger == nil {
+		vm.Debugger = NewDebugger(vm, os.Stdin, os.Stdout)
+	}
+	return nil
+}
+
+func (vm *VirtualMachine) memoryBytes() int {
+	if vm.MemoryBytes <= 0 {
+		return DefaultMemorySize
+	}
+	return vm.MemoryBytes
 }
 
 func (vm *VirtualMachine) loadSpritesInMemory() {
 	for idx := range spriteData {
 		vm.memory[common.SpriteStartOffsetBytes + idx] = spriteData[idx]
 	}
+	for idx := range bigSpriteData {
+		vm.memory[common.BigSpriteStartOffsetBytes + idx] = bigSpriteData[idx]
+	}
 }
 
 // Run is the main entry point for the VM
-// it repeatedly goes through the fetch/execute cycle
+// it repeatedly runs frames, all on the calling goroutine
 func (vm *VirtualMachine) Run() error {
-	vm.Keyboard.Start()
-	go vm.delayTimerTick()
-	go vm.soundTimerTick()
+	if vm.Input != nil {
+		vm.Input.Start()
+		defer vm.Input.Stop()
+	}
+	vm.frameClk = time.NewTicker(time.Second / frameRate)
+	defer vm.frameClk.Stop()
 	for {
 		// Wait for tick before proceeding
-		<- vm.Clk.C
-		if vm.Debug {
-			instrBytes := vm.memory[vm.pc : vm.pc+2]
-			debugInst := common.ParseHexInstruction(instrBytes, int(vm.pc))
-			fmt.Print("> ")
-			debugInst.Print()
-			reader := bufio.NewReader(os.Stdin)
-			// TODO(abhinandj): Add support for breakpoints.
-			for {
-				input, _ := reader.ReadString('\n')
-				if strings.ToLower(input) == "n\n" {
-					break
-				}
-			}
+		<- vm.frameClk.C
+		end, err := vm.runFrame()
+		if err != nil {
+			return err
 		}
-		instruction, end := vm.fetch()
 		if end {
-			vm.Clk.Stop()
-			break
+			return nil
 		}
-		err := vm.execute(instruction)
-		if err != nil {
-			return fmt.Errorf("could not execute instruction: %v", err)
+	}
+}
+
+// RunFrames runs n frames as fast as possible, without pacing them
+// at 60Hz. It reports whether the program ended before that.
+func (vm *VirtualMachine) RunFrames(n int) (bool, error) {
+	for frame := 0; frame < n; frame++ {
+		end, err := vm.runFrame()
+		if err != nil || end {
+			return end, err
 		}
-		vm.handleKeyInputs()
 	}
-	vm.Keyboard.Stop()
-	return nil
+	return false, nil
 }
 
+// RunCycles runs n instructions as fast as possible, finishing
+// frames along the way. It reports whether the program ended before that.
+func (vm *VirtualMachine) RunCycles(n int) (bool, error) {
+	for c := 0; c < n; c++ {
+		_, end, err := vm.cycle()
+		if err != nil || end {
+			return end, err
+		}
+	}
+	return false, nil
+}
 
-func (vm *VirtualMachine) delayTimerTick() {
+// Cycle runs one instruction for debuggers driving the VM themselves,
+// reporting whether it finished a frame and whether the program ended.
+func (vm *VirtualMachine) Cycle() (bool, bool, error) {
+	return vm.cycle()
+}
+
+// runFrame executes instructions until the frame is finished.
+// It reports whether the program has ended.
+func (vm *VirtualMachine) runFrame() (bool, error) {
 	for {
-		<- vm.Clk.C
-		if vm.dt > 0 {
-			vm.dt -= 1
+		frameDone, end, err := vm.cycle()
+		if err != nil || end || frameDone {
+			return end, err
 		}
 	}
 }
 
-func (vm *VirtualMachine) soundTimerTick() {
-	for {
-		<- vm.Clk.C
-		if vm.ds > 0 {
-			vm.ds -= 1
-			// TODO: Add support for :beep: sound.
+// cycle runs one instruction. Once cyclesPerFrame instructions have run,
+// or the display wait quirk kicks in, it finishes the frame by ticking the
+// timers, polling input and presenting the display.
+func (vm *VirtualMachine) cycle() (bool, bool, error) {
+	if vm.Debugger != nil && vm.Debugger.beforeStep() {
+		return false, true, nil
+	}
+	if vm.Rewind != nil && vm.frameCycles == 0 {
+		if err := vm.Rewind.record(vm); err != nil {
+			return false, false, fmt.Errorf("unable to record rewind snapshot: %v", err)
+		}
+	}
+	var inst common.Instruction
+	var before Registers
+	if vm.Tracer != nil {
+		inst, before = vm.decode(vm.pc), vm.Registers()
+	}
+	end, err := vm.step()
+	if err != nil {
+		return false, false, err
+	}
+	if end {
+		vm.present()
+		return false, true, nil
+	}
+	vm.cycles++
+	if vm.Tracer != nil {
+		// The fetch wraps PC around memory when not halting on errors
+		if vm.instPC != inst.Address {
+			inst = vm.decode(vm.instPC)
 		}
+		vm.Tracer.record(inst, before, vm.Registers())
+	}
+	vm.frameCycles++
+	if vm.frameCycles < vm.cyclesPerFrame && !vm.waitVBlank {
+		return false, false, nil
+	}
+	vm.frameCycles = 0
+	vm.waitVBlank = false
+	vm.nextFrameBudget()
+	vm.tickTimers()
+	vm.handleKeyInputs()
+	vm.present()
+	// Sources running out of input, such as replays, end the program
+	if finite, ok := vm.Input.(input.FiniteSource); ok && finite.Done() {
+		return true, true, nil
+	}
+	return true, false, nil
+}
+
+// nextFrameBudget sets how many instructions the next frame runs, so that
+// clock speeds that are not a multiple of the frame rate are kept on
+// average. Frames run at least one instruction.
+func (vm *VirtualMachine) nextFrameBudget() {
+	vm.cyclesPerFrame = vm.clockSpeed / frameRate
+	vm.clockCarry += vm.clockSpeed % frameRate
+	if vm.clockCarry >= frameRate {
+		vm.clockCarry -= frameRate
+		vm.cyclesPerFrame++
+	}
+	vm.cyclesPerFrame = max(1, vm.cyclesPerFrame)
+}
+
+// step runs a single fetch/execute cycle.
+func (vm *VirtualMachine) step() (bool, error) {
+	instruction, end, err := vm.fetch()
+	if err != nil || end {
+		return end, err
+	}
+	if err := vm.execute(instruction); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (vm *VirtualMachine) tickTimers() {
+	if vm.dt > 0 {
+		vm.dt -= 1
+	}
+	if vm.ds > 0 {
+		vm.ds -= 1
+		// TODO: Add support for :beep: sound.
+	}
+}
+
+func (vm *VirtualMachine) present() {
+	if vm.displayDirty {
+		vm.Display.Render()
+		vm.displayDirty = false
 	}
 }
 
@@ -138,9 +326,22 @@ func (vm *VirtualMachine) setKeyUp(index byte) {
 
 // TODO: Need to add a delay/timer to handle timing issues.
 func (vm *VirtualMachine) handleKeyInputs() {
-	vm.Keyboard.DoKeyEventUpdates()
+	if vm.Recorder != nil {
+		defer func() { vm.Recorder.record(vm.keypad) }()
+	}
+	if vm.Input == nil {
+		return
+	}
+	if hotkeys, ok := vm.Input.(input.HotkeySource); ok {
+		for key, ok := hotkeys.PollHotkey(); ok; key, ok = hotkeys.PollHotkey() {
+			if action, bound := vm.Hotkeys[key]; bound {
+				action()
+			}
+		}
+	}
+	vm.Input.DoKeyEventUpdates()
 	for _, idx := range input.KeyMap {
-		if vm.Keyboard.IsPressed(idx) {
+		if vm.Input.IsPressed(idx) {
 			vm.setKeyDown(idx)
 			// fmt.Printf("Pressed %X\n", idx)
 		} else {
@@ -149,72 +350,96 @@ func (vm *VirtualMachine) handleKeyInputs() {
 	}
 }
 
-func (vm *VirtualMachine) fetch() (*common.Opcode, bool) {
-	if vm.pc >= uint16(len(vm.memory)) {
-		return nil, true
+// fetch reads the instruction at PC. It reports whether the program has
+// ended, which it does once halted or when running off the end of memory,
+// as in earlier versions, unless wrapping on errors. An instruction
+// straddling the end of memory is an error.
+func (vm *VirtualMachine) fetch() (*common.Opcode, bool, error) {
+	if vm.halted {
+		return nil, true, nil
 	}
-	instrBytes := vm.memory[vm.pc : vm.pc+2]
-	opcode := binary.BigEndian.Uint16(instrBytes)
-	vm.pc += 2
-	return common.ParseOpcode(opcode), false
-}
-
-func (vm *VirtualMachine) execute(opcode *common.Opcode) error {
-	switch opcode.NibbleUpper {
-	case 0x00: {
-		switch opcode.LowerByte {
-		case 0xE0: vm._CLS()
-		case 0xEE: vm._RET()
-		default: return common.UnknownOpcodeErr(opcode.Opcode)
-		}
+	if int(vm.pc) >= len(vm.memory) && vm.OnError != WrapOnError {
+		return nil, true, nil
 	}
-	case 0x01: vm._JP(opcode.Addr)
-	case 0x02: vm._CALL(opcode.Addr)
-	case 0x03: vm._SEVal(opcode.NibbleX, opcode.LowerByte)
-	case 0x04: vm._SNEVal(opcode.NibbleX, opcode.LowerByte)
-	case 0x05: vm._SE(opcode.NibbleX, opcode.NibbleY)
-	case 0x06: vm._LDVal(opcode.NibbleX, opcode.LowerByte)
-	case 0x07: vm._ADDVal(opcode.NibbleX, opcode.LowerByte)
-	case 0x08: {
-		switch opcode.NibbleLower {
-		case 0x00: vm._LD(opcode.NibbleX, opcode.NibbleY)
-		case 0x01: vm._OR(opcode.NibbleX, opcode.NibbleY)
-		case 0x02: vm._AND(opcode.NibbleX, opcode.NibbleY)
-		case 0x03: vm._XOR(opcode.NibbleX, opcode.NibbleY)
-		case 0x04: vm._ADD(opcode.NibbleX, opcode.NibbleY)
-		case 0x05: vm._SUB(opcode.NibbleX, opcode.NibbleY)
-		case 0x06: vm._SHR(opcode.NibbleX)
-		case 0x07: vm._SUBN(opcode.NibbleX, opcode.NibbleY)
-		case 0x0E: vm._SHL(opcode.NibbleX)
-		default: return common.UnknownOpcodeErr(opcode.Opcode)
+	vm.instPC = vm.pc
+	if int(vm.pc) + 1 >= len(vm.memory) {
+		if !vm.fault(MemoryOutOfBounds, max(int(vm.pc), len(vm.memory))) {
+			err := vm.runErr
+			vm.runErr = nil
+			return nil, false, err
 		}
-	}
-	case 0x09: vm._SNE(opcode.NibbleX, opcode.NibbleY)
-	case 0x0A: vm._LDI(opcode.Addr)
-	case 0x0B: vm._JPAddr(opcode.Addr)
-	case 0x0C: vm._RNG(opcode.NibbleX, opcode.LowerByte)
-	case 0x0D: vm._DRW(opcode.NibbleX, opcode.NibbleY, opcode.NibbleLower)
-	case 0x0E: {
-		switch opcode.LowerByte {
-		case 0x9E: vm._SKP(opcode.NibbleX)
-		case 0xA1: vm._SKPN(opcode.NibbleX)
+		if vm.OnError == IgnoreErrors {
+			return nil, true, nil
 		}
+		vm.pc = uint16(int(vm.pc) % len(vm.memory))
+		vm.instPC = vm.pc
 	}
-	case 0x0F: {
-		switch opcode.LowerByte {
-		case 0x0A: vm._LDKEY(opcode.NibbleX)
-		case 0x07: vm._STRDT(opcode.NibbleX)
-		case 0x15: vm._LDDT(opcode.NibbleX)
-		case 0x18: vm._LDDS(opcode.NibbleX)
-		case 0x1E: vm._ADDI(opcode.NibbleX)
-		case 0x29: vm._LDSPRITE(opcode.NibbleX)
-		case 0x33: vm._LDBCD(opcode.NibbleX)
-		case 0x55: vm._STR(opcode.NibbleX)
-		case 0x65: vm._LDR(opcode.NibbleX)
-		default: return common.UnknownOpcodeErr(opcode.Opcode)
-		}
+	opcode := vm.readWord(vm.pc)
+	vm.pc += 2
+	return common.ParseOpcode(opcode), false, nil
+}
+
+// execute dispatches on the decode table shared with the disassembler.
+func (vm *VirtualMachine) execute(opcode *common.Opcode) error {
+	x, y := opcode.NibbleX, opcode.NibbleY
+	switch opcode.Op {
+	case common.OpCLS: vm._CLS()
+	case common.OpRET: vm._RET()
+	case common.OpSCD: vm._SCD(opcode.NibbleLower)
+	case common.OpSCU: vm._SCU(opcode.NibbleLower)
+	case common.OpSCR: vm._SCR()
+	case common.OpSCL: vm._SCL()
+	case common.OpEXIT: vm._EXIT()
+	case common.OpLOW: vm._LOW()
+	case common.OpHIGH: vm._HIGH()
+	case common.OpJP: vm._JP(opcode.Addr)
+	case common.OpCALL: vm._CALL(opcode.Addr)
+	case common.OpSEVal: vm._SEVal(x, opcode.LowerByte)
+	case common.OpSNEVal: vm._SNEVal(x, opcode.LowerByte)
+	case common.OpSE: vm._SE(x, y)
+	case common.OpSAVE: vm._SAVERANGE(x, y)
+	case common.OpLOAD: vm._LOADRANGE(x, y)
+	case common.OpLDVal: vm._LDVal(x, opcode.LowerByte)
+	case common.OpADDVal: vm._ADDVal(x, opcode.LowerByte)
+	case common.OpLD: vm._LD(x, y)
+	case common.OpOR: vm._OR(x, y)
+	case common.OpAND: vm._AND(x, y)
+	case common.OpXOR: vm._XOR(x, y)
+	case common.OpADD: vm._ADD(x, y)
+	case common.OpSUB: vm._SUB(x, y)
+	case common.OpSHR: vm._SHR(x, y)
+	case common.OpSUBN: vm._SUBN(x, y)
+	case common.OpSHL: vm._SHL(x, y)
+	case common.OpSNE: vm._SNE(x, y)
+	case common.OpLDI: vm._LDI(opcode.Addr)
+	case common.OpJPV0: vm._JPAddr(opcode.Addr)
+	case common.OpRND: vm._RNG(x, opcode.LowerByte)
+	case common.OpDRW: vm._DRW(x, y, opcode.NibbleLower)
+	case common.OpSKP: vm._SKP(x)
+	case common.OpSKNP: vm._SKPN(x)
+	case common.OpLDILong: vm._LDILONG()
+	case common.OpPLANE: vm._PLANE(x)
+	case common.OpAUDIO: vm._AUDIO()
+	case common.OpLDVxDT: vm._STRDT(x)
+	case common.OpLDKEY: vm._LDKEY(x)
+	case common.OpLDDT: vm._LDDT(x)
+	case common.OpLDST: vm._LDDS(x)
+	case common.OpADDI: vm._ADDI(x)
+	case common.OpLDF: vm._LDSPRITE(x)
+	case common.OpLDHF: vm._LDBIGSPRITE(x)
+	case common.OpLDB: vm._LDBCD(x)
+	case common.OpPITCH: vm._PITCH(x)
+	case common.OpSTR: vm._STR(x)
+	case common.OpLDR: vm._LDR(x)
+	case common.OpSTRRPL: vm._STRRPL(x)
+	case common.OpLDRPL: vm._LDRPL(x)
+	// SYS calls into native code are not supported
+	default: vm.fault(UnknownOpcode, 0)
 	}
-	default: return common.UnknownOpcodeErr(opcode.Opcode)
+	if vm.runErr != nil {
+		err := vm.runErr
+		vm.runErr = nil
+		return err
 	}
 	return nil
 }
@@ -234,4 +459,101 @@ func (vm *VirtualMachine) isVFSet() bool {
 func (vm *VirtualMachine) isOverflow(x, y byte) bool {
 	res := x + y
 	return !((res > x) == (y > 0))
-}
\ No newline at end of file
+}
+
+// skip jumps over the next instruction, which is 4 bytes
+// long for the XO-CHIP long I load.
+func (vm *VirtualMachine) skip() {
+	if vm.readWord(vm.pc) == 0xF000 {
+		vm.pc += 4
+		return
+	}
+	vm.pc += 2
+}
+
+// random returns the next byte of a splitmix64 generator, whose whole
+// state is one word so that save states can capture it.
+func (vm *VirtualMachine) random() byte {
+	vm.rng += 0x9E3779B97F4A7C15
+	z := vm.rng
+	z = (z ^ z >> 30) * 0xBF58476D1CE4E5B9
+	z = (z ^ z >> 27) * 0x94D049BB133111EB
+	return byte((z ^ z >> 31) >> 56)
+}
+
+// decode decodes the instruction at addr for display.
+func (vm *VirtualMachine) decode(addr uint16) common.Instruction {
+	end := min(int(addr) + 4, len(vm.memory))
+	if int(addr) >= end {
+		return common.Instruction{Address: addr, Name: "UNK", Size: 2}
+	}
+	return common.DecodeInstruction(vm.memory[addr:end], addr)
+}
+
+func (vm *VirtualMachine) readWord(addr uint16) uint16 {
+	hi := vm.memory[int(addr) % len(vm.memory)]
+	lo := vm.memory[(int(addr) + 1) % len(vm.memory)]
+	return uint16(hi) << 8 | uint16(lo)
+}
+
+// readMemory and writeMemory are the data access path of the instruction
+// handlers, so that the debugger can watch addresses.
+func (vm *VirtualMachine) readMemory(addr int) byte {
+	addr, ok := vm.memoryAddress(addr)
+	if !ok {
+		return 0
+	}
+	if vm.Debugger != nil {
+		vm.Debugger.memoryAccess(addr, vm.memory[addr], false)
+	}
+	return vm.memory[addr]
+}
+
+func (vm *VirtualMachine) writeMemory(addr int, value byte) {
+	addr, ok := vm.memoryAddress(addr)
+	if !ok {
+		return
+	}
+	vm.memory[addr] = value
+	if vm.Debugger != nil {
+		vm.Debugger.memoryAccess(addr, value, true)
+	}
+}
+
+// noteSpriteRead reports the sprite bytes read by a draw, which the
+// display reads from memory directly, to the debugger.
+func (vm *VirtualMachine) noteSpriteRead(n byte) {
+	if vm.Debugger == nil {
+		return
+	}
+	for idx := 0; idx < vm.spriteBytes(n); idx++ {
+		addr := (int(vm.i) + idx) % len(vm.memory)
+		vm.Debugger.memoryAccess(addr, vm.memory[addr], false)
+	}
+}
+
+// spriteBytes is the number of bytes a draw of height n reads.
+func (vm *VirtualMachine) spriteBytes(n byte) int {
+	size := int(n)
+	if n == 0 {
+		// 16x16 sprite
+		size = 32
+	}
+	return size * bits.OnesCount8(vm.planes)
+}
+
+// registerRange lists the registers from x to y inclusive,
+// in descending order when x > y.
+func registerRange(x, y byte) []byte {
+	var regs []byte
+	if x <= y {
+		for reg := x; reg <= y; reg++ {
+			regs = append(regs, reg)
+		}
+		return regs
+	}
+	for reg := int(x); reg >= int(y); reg-- {
+		regs = append(regs, byte(reg))
+	}
+	return regs
+}
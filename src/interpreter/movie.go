@@ -0,0 +1,183 @@
+package interpreter
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const movieVersion = 1
+
+// Movie is a recording of the keypad state of each frame of a run, along
+// with everything else the run depends on, so that it can be replayed
+// exactly. Movie files are text:
+//
+//	version 1
+//	rom 9f86d081...
+//	seed 0
+//	clock_speed 700
+//	quirks {"ShiftUsesVy":true,...}
+//	memory_size 4096
+//	on_error halt
+//	frames
+//	0000
+//	0011
+//
+// rom is the SHA-256 of the ROM, memory_size is optional and defaults to
+// 4K, on_error is optional and defaults to halt, and each line after
+// frames is the keypad state of a frame in hexadecimal, bit n being set
+// when key n is pressed.
+type Movie struct {
+	ROM string
+	Seed uint64
+	ClockSpeed int
+	Quirks Quirks
+	MemorySize int
+	OnError ErrorPolicy
+	Frames []uint16
+}
+
+// ROMHash identifies a ROM in movies.
+func ROMHash(program []byte) string {
+	sum := sha256.Sum256(program)
+	return hex.EncodeToString(sum[:])
+}
+
+func keypadMask(keypad [16]bool) uint16 {
+	var mask uint16
+	for idx, pressed := range keypad {
+		if pressed {
+			mask |= 1 << idx
+		}
+	}
+	return mask
+}
+
+func (m *Movie) writeHeader(w io.Writer) error {
+	quirks, err := json.Marshal(m.Quirks)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "version %d\nrom %s\nseed %d\nclock_speed %d\nquirks %s\nmemory_size %d\non_error %s\nframes\n", movieVersion, m.ROM, m.Seed, m.ClockSpeed, quirks, m.memorySize(), m.OnError)
+	return err
+}
+
+func (m *Movie) memorySize() int {
+	if m.MemorySize <= 0 {
+		return DefaultMemorySize
+	}
+	return m.MemorySize
+}
+
+// ReadMovie reads a movie file.
+func ReadMovie(path string) (*Movie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open movie '%s': %v", path, err)
+	}
+	defer f.Close()
+	m, err := readMovie(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read movie '%s': %v", path, err)
+	}
+	return m, nil
+}
+
+func readMovie(r io.Reader) (*Movie, error) {
+	m := &Movie{}
+	scanner := bufio.NewScanner(r)
+	inFrames := false
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if inFrames {
+			mask, err := strconv.ParseUint(line, 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid keypad state '%s'", lineNo, line)
+			}
+			m.Frames = append(m.Frames, uint16(mask))
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		var err error
+		switch key {
+		case "version":
+			var version int
+			if version, err = strconv.Atoi(value); err == nil && version != movieVersion {
+				return nil, fmt.Errorf("unsupported movie version %d, expected %d", version, movieVersion)
+			}
+		case "rom": m.ROM = value
+		case "seed": m.Seed, err = strconv.ParseUint(value, 10, 64)
+		case "clock_speed": m.ClockSpeed, err = strconv.Atoi(value)
+		case "quirks": err = json.Unmarshal([]byte(value), &m.Quirks)
+		case "memory_size": m.MemorySize, err = strconv.Atoi(value)
+		case "on_error": m.OnError, err = ParseErrorPolicy(value)
+		case "frames": inFrames = true
+		default: return nil, fmt.Errorf("line %d: unknown field '%s'", lineNo, key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %v", lineNo, key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !inFrames {
+		return nil, fmt.Errorf("no frames")
+	}
+	return m, nil
+}
+
+// MovieRecorder writes a movie as frames are run, so that it survives
+// the program being killed.
+type MovieRecorder struct {
+	f *os.File
+	w *bufio.Writer
+	err error
+}
+
+// CreateMovie starts recording a movie with the header of m.
+func CreateMovie(path string, m *Movie) (*MovieRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create movie '%s': %v", path, err)
+	}
+	r := &MovieRecorder{f: f, w: bufio.NewWriter(f)}
+	if err := m.writeHeader(r.w); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to write movie '%s': %v", path, err)
+	}
+	return r, nil
+}
+
+// record appends the keypad state of a frame.
+func (r *MovieRecorder) record(keypad [16]bool) {
+	if r.err != nil {
+		return
+	}
+	if _, r.err = fmt.Fprintf(r.w, "%04X\n", keypadMask(keypad)); r.err == nil {
+		r.err = r.w.Flush()
+	}
+}
+
+// Close finishes the movie and reports the first error writing it.
+func (r *MovieRecorder) Close() error {
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err := r.f.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		return fmt.Errorf("unable to write movie: %v", r.err)
+	}
+	return nil
+}
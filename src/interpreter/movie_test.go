@@ -0,0 +1,79 @@
+package interpreter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMovieRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.movie")
+	want := &Movie{
+		ROM:        ROMHash([]byte{0x12, 0x00}),
+		Seed:       42,
+		ClockSpeed: 1000,
+		Quirks:     Quirks{ShiftUsesVy: true},
+		MemorySize: XOChipMemorySize,
+		OnError:    WrapOnError,
+	}
+	r, err := CreateMovie(path, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keys := range [][]int{nil, {0x1}, {0x0, 0xF}} {
+		var keypad [16]bool
+		for _, key := range keys {
+			keypad[key] = true
+		}
+		r.record(keypad)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadMovie(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Frames = []uint16{0x0000, 0x0002, 0x8001}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got movie %+v, want %+v", got, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("\non_error wrap\nframes\n0000\n0002\n8001\n")) {
+		t.Errorf("movie file:\n%s", data)
+	}
+}
+
+func TestReadMovie(t *testing.T) {
+	// Movies from before the error policy was recorded were run with the
+	// default one
+	m, err := readMovie(strings.NewReader("version 1\nrom abc\nseed 1\nclock_speed 700\nquirks {}\nframes\n0001\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.OnError != HaltOnError || m.memorySize() != DefaultMemorySize || len(m.Frames) != 1 {
+		t.Errorf("got movie %+v", m)
+	}
+	tests := []struct {
+		movie string
+		want  string
+	}{
+		{"version 2\nframes\n", "unsupported movie version 2, expected 1"},
+		{"on_error crash\nframes\n", "line 1: invalid on_error: unknown error policy 'crash' (available: halt, ignore, wrap)"},
+		{"seed x\nframes\n", "line 1: invalid seed"},
+		{"rom abc\nspeed 700\n", "line 2: unknown field 'speed'"},
+		{"frames\n0000\nkey\n", "line 3: invalid keypad state 'key'"},
+		{"rom abc\n", "no frames"},
+	}
+	for _, tc := range tests {
+		if _, err := readMovie(strings.NewReader(tc.movie)); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("readMovie(%q) = %v, want %q", tc.movie, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quirks captures the behaviours that differ between CHIP-8 platforms.
+// The zero value matches the interpreter's historical behaviour.
+type Quirks struct {
+	// 8xy6/8xyE shift Vy into Vx instead of shifting Vx in place.
+	ShiftUsesVy bool
+	// Fx55/Fx65 leave I pointing past the last register stored/loaded.
+	LoadStoreIncrementsI bool
+	// Bnnn jumps to nnn + Vx (x being the upper nibble of nnn) instead of nnn + V0.
+	JumpUsesVx bool
+	// 8xy1/8xy2/8xy3 reset VF to 0.
+	LogicResetsVF bool
+	// Sprites are clipped at the screen edges instead of wrapping around.
+	ClipSprites bool
+	// Dxyn waits for the next vertical blank before execution continues.
+	DisplayWait bool
+}
+
+// Sizes of the address space of the supported platforms. Only XO-CHIP
+// goes beyond the original 4K.
+const (
+	DefaultMemorySize = 4096
+	XOChipMemorySize = 65536
+)
+
+// QuirkPresets holds the quirk profiles for the supported platforms.
+var QuirkPresets = map[string]Quirks{
+	// The interpreter's behaviour before quirks were configurable, kept as
+	// the default so that ROMs run as they always did
+	"legacy": {},
+	"vip": {
+		ShiftUsesVy:          true,
+		LoadStoreIncrementsI: true,
+		LogicResetsVF:        true,
+		ClipSprites:          true,
+		DisplayWait:          true,
+	},
+	"chip48": {
+		JumpUsesVx:  true,
+		ClipSprites: true,
+	},
+	"schip": {
+		JumpUsesVx:  true,
+		ClipSprites: true,
+	},
+	"xochip": {
+		ShiftUsesVy:          true,
+		LoadStoreIncrementsI: true,
+	},
+}
+
+// QuirksPreset returns the quirk profile registered under name.
+func QuirksPreset(name string) (Quirks, error) {
+	q, ok := QuirkPresets[strings.ToLower(name)]
+	if !ok {
+		return Quirks{}, fmt.Errorf("unknown quirks preset '%s' (available: %s)", name, strings.Join(QuirkPresetNames(), ", "))
+	}
+	return q, nil
+}
+
+// QuirkPresetNames lists the available presets in a stable order.
+func QuirkPresetNames() []string {
+	names := make([]string, 0, len(QuirkPresets))
+	for name := range QuirkPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetMemorySize returns the size of the address space of the platform
+// a quirks preset emulates.
+func PresetMemorySize(name string) int {
+	if strings.ToLower(name) == "xochip" {
+		return XOChipMemorySize
+	}
+	return DefaultMemorySize
+}
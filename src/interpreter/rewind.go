@@ -0,0 +1,131 @@
+package interpreter
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+)
+
+// Rewind keeps compressed save states of the VM taken at the start of
+// each frame, in a ring buffer holding the most recent ones.
+type Rewind struct {
+	snapshots []rewindSnapshot
+	// Index of the oldest snapshot and number of snapshots held
+	first int
+	count int
+	buf bytes.Buffer
+	w *flate.Writer
+}
+
+type rewindSnapshot struct {
+	// Instructions run before the snapshot was taken
+	cycles uint64
+	state []byte
+}
+
+// NewRewind keeps the last frames frames of history.
+func NewRewind(frames int) *Rewind {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &Rewind{snapshots: make([]rewindSnapshot, max(1, frames)), w: w}
+}
+
+func (r *Rewind) at(idx int) *rewindSnapshot {
+	return &r.snapshots[(r.first + idx) % len(r.snapshots)]
+}
+
+// record takes a snapshot, replacing the latest one if the VM has not
+// run any instruction since.
+func (r *Rewind) record(vm *VirtualMachine) error {
+	r.buf.Reset()
+	r.w.Reset(&r.buf)
+	if err := vm.SaveState(r.w); err != nil {
+		return err
+	}
+	if err := r.w.Close(); err != nil {
+		return err
+	}
+	if r.count > 0 && r.at(r.count - 1).cycles == vm.cycles {
+		r.count--
+	}
+	if r.count == len(r.snapshots) {
+		r.first = (r.first + 1) % len(r.snapshots)
+		r.count--
+	}
+	snap := r.at(r.count)
+	snap.cycles = vm.cycles
+	// Reuse the buffer of the snapshot being overwritten
+	snap.state = append(snap.state[:0], r.buf.Bytes()...)
+	r.count++
+	return nil
+}
+
+// restore loads snapshot idx into the VM and drops the ones after it.
+func (r *Rewind) restore(vm *VirtualMachine, idx int) error {
+	snap := r.at(idx)
+	if err := vm.LoadState(flate.NewReader(bytes.NewReader(snap.state))); err != nil {
+		return fmt.Errorf("unable to restore snapshot: %v", err)
+	}
+	vm.cycles = snap.cycles
+	r.count = idx + 1
+	return nil
+}
+
+// RewindFrames goes back to the start of the nth frame before the current
+// one. It returns the number of frames actually rewound, which is lower
+// when the history does not go back that far.
+func (vm *VirtualMachine) RewindFrames(n int) (int, error) {
+	r := vm.Rewind
+	if r == nil || r.count == 0 {
+		return 0, fmt.Errorf("no rewind history")
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("invalid number of frames %d", n)
+	}
+	latest := r.count - 1
+	// Part way through a frame, its start counts as the first frame back
+	if r.at(latest).cycles != vm.cycles {
+		n--
+		latest++
+	}
+	idx := max(0, r.count - 1 - n)
+	if err := r.restore(vm, idx); err != nil {
+		return 0, err
+	}
+	return latest - idx, nil
+}
+
+// StepBack goes back n instructions, by restoring the last snapshot
+// before them and running forward to the instruction.
+func (vm *VirtualMachine) StepBack(n int) error {
+	r := vm.Rewind
+	if r == nil || r.count == 0 {
+		return fmt.Errorf("no rewind history")
+	}
+	if uint64(n) > vm.cycles - r.at(0).cycles {
+		return fmt.Errorf("history only goes back %d instructions", vm.cycles - r.at(0).cycles)
+	}
+	target := vm.cycles - uint64(n)
+	idx := r.count - 1
+	for r.at(idx).cycles > target {
+		idx--
+	}
+	if err := r.restore(vm, idx); err != nil {
+		return err
+	}
+	// Run forward without stopping in the debugger or tracing again, and
+	// without polling the input or running hotkeys, so that the keypad
+	// stays as the snapshot recorded it
+	debugger, tracer, source, recorder := vm.Debugger, vm.Tracer, vm.Input, vm.Recorder
+	vm.Debugger, vm.Tracer, vm.Input, vm.Recorder = nil, nil, nil, nil
+	defer func() { vm.Debugger, vm.Tracer, vm.Input, vm.Recorder = debugger, tracer, source, recorder }()
+	for vm.cycles < target {
+		_, end, err := vm.cycle()
+		if err != nil {
+			return fmt.Errorf("unable to run forward to the instruction: %v", err)
+		}
+		if end {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package interpreter_test
+
+import (
+	"bytes"
+	"testing"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/input"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+// newCounterVM runs 4 instructions a frame of a program adding one to V0
+// with each instruction, so V0 counts the instructions run.
+func newCounterVM(frames int) *interpreter.VirtualMachine {
+	program := bytes.Repeat([]byte{0x70, 0x01}, 200)
+	vm := &interpreter.VirtualMachine{
+		Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+		Rewind:  interpreter.NewRewind(frames),
+	}
+	vm.Init(program, 240)
+	return vm
+}
+
+func instructions(vm *interpreter.VirtualMachine) int {
+	return int(vm.Registers().V[0])
+}
+
+func TestRewindFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		// Frames of history kept
+		size int
+		// Instructions run before rewinding
+		run    int
+		rewind int
+		// Frames actually rewound and instructions run after rewinding
+		wantFrames int
+		want       int
+	}{
+		{name: "frame start", size: 10, run: 12, rewind: 1, wantFrames: 1, want: 8},
+		{name: "several frames", size: 10, run: 12, rewind: 2, wantFrames: 2, want: 4},
+		{name: "partial frame", size: 10, run: 14, rewind: 1, wantFrames: 1, want: 12},
+		{name: "partial frame back two", size: 10, run: 14, rewind: 2, wantFrames: 2, want: 8},
+		{name: "first frame", size: 10, run: 2, rewind: 1, wantFrames: 1, want: 0},
+		{name: "before the history", size: 10, run: 12, rewind: 5, wantFrames: 3, want: 0},
+		{name: "wrapped", size: 3, run: 40, rewind: 2, wantFrames: 2, want: 32},
+		{name: "wrapped oldest", size: 3, run: 40, rewind: 3, wantFrames: 3, want: 28},
+		{name: "wrapped before the history", size: 3, run: 40, rewind: 10, wantFrames: 3, want: 28},
+		{name: "wrapped partial frame", size: 3, run: 41, rewind: 10, wantFrames: 3, want: 32},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vm := newCounterVM(tc.size)
+			if _, err := vm.RunCycles(tc.run); err != nil {
+				t.Fatal(err)
+			}
+			frames, err := vm.RewindFrames(tc.rewind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frames != tc.wantFrames || instructions(vm) != tc.want {
+				t.Errorf("rewound %d frames to instruction %d, want %d frames to instruction %d", frames, instructions(vm), tc.wantFrames, tc.want)
+			}
+		})
+	}
+}
+
+// Running the same frame again replaces its snapshot instead of pushing
+// older frames out of the history.
+func TestRewindReplacesSnapshot(t *testing.T) {
+	vm := newCounterVM(3)
+	if _, err := vm.RunFrames(5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := vm.RewindFrames(1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := vm.RunFrames(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A changed state at the same instruction replaces the snapshot too
+	if _, err := vm.RewindFrames(1); err != nil {
+		t.Fatal(err)
+	}
+	regs := vm.Registers()
+	regs.V[1] = 0x42
+	if err := vm.SetRegisters(regs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RunFrames(1); err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := vm.RewindFrames(1); err != nil || frames != 1 || vm.Registers().V[1] != 0x42 {
+		t.Errorf("rewound %d frames, %v, to V1 = %#x, want the snapshot taken after setting V1 to 0x42", frames, err, vm.Registers().V[1])
+	}
+	frames, err := vm.RewindFrames(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 2 || instructions(vm) != 8 {
+		t.Errorf("rewound %d frames to instruction %d, want 2 frames to instruction 8", frames, instructions(vm))
+	}
+}
+
+func TestStepBack(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		run  int
+		back int
+		want int
+	}{
+		{name: "same frame", size: 10, run: 14, back: 1, want: 13},
+		{name: "frame start", size: 10, run: 14, back: 2, want: 12},
+		{name: "previous frame", size: 10, run: 14, back: 3, want: 11},
+		{name: "across frames", size: 10, run: 14, back: 9, want: 5},
+		{name: "whole history", size: 10, run: 14, back: 14, want: 0},
+		{name: "nothing", size: 10, run: 14, back: 0, want: 14},
+		{name: "wrapped", size: 3, run: 41, back: 9, want: 32},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vm := newCounterVM(tc.size)
+			if _, err := vm.RunCycles(tc.run); err != nil {
+				t.Fatal(err)
+			}
+			if err := vm.StepBack(tc.back); err != nil {
+				t.Fatal(err)
+			}
+			if instructions(vm) != tc.want {
+				t.Errorf("stepped back to instruction %d, want %d", instructions(vm), tc.want)
+			}
+		})
+	}
+}
+
+// Stepping back across a frame gives the state the VM had at the time,
+// timers, random numbers and the display included.
+func TestStepBackState(t *testing.T) {
+	vm := newStateVM(t, 0)
+	vm.Rewind = interpreter.NewRewind(10)
+	if _, err := vm.RunCycles(30); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.StepBack(15); err != nil {
+		t.Fatal(err)
+	}
+	want := newStateVM(t, 0)
+	if _, err := want.RunCycles(15); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saveState(t, vm), saveState(t, want)) {
+		t.Fatal("state after stepping back differs from running up to the instruction")
+	}
+	// The history carries on from the instruction stepped back to
+	if _, err := vm.RunCycles(15); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := want.RunCycles(15); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saveState(t, vm), saveState(t, want)) {
+		t.Error("state after running forward again differs")
+	}
+}
+
+func TestRewindErrors(t *testing.T) {
+	vm := newCounterVM(3)
+	if _, err := vm.RewindFrames(1); err == nil || err.Error() != "no rewind history" {
+		t.Errorf("rewinding before running gave %v", err)
+	}
+	if err := vm.StepBack(1); err == nil || err.Error() != "no rewind history" {
+		t.Errorf("stepping back before running gave %v", err)
+	}
+	if _, err := vm.RunCycles(41); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RewindFrames(0); err == nil || err.Error() != "invalid number of frames 0" {
+		t.Errorf("rewinding 0 frames gave %v", err)
+	}
+	if err := vm.StepBack(10); err == nil || err.Error() != "history only goes back 9 instructions" {
+		t.Errorf("stepping back past the history gave %v", err)
+	}
+	if instructions(vm) != 41 {
+		t.Errorf("failed calls moved the VM to instruction %d", instructions(vm))
+	}
+	vm.Rewind = nil
+	if _, err := vm.RewindFrames(1); err == nil || err.Error() != "no rewind history" {
+		t.Errorf("rewinding without history gave %v", err)
+	}
+}
+
+// pollCounter is a script source counting how often the VM polls it.
+type pollCounter struct {
+	*input.Script
+	polls int
+}
+
+func (p *pollCounter) DoKeyEventUpdates() {
+	p.polls++
+	p.Script.DoKeyEventUpdates()
+}
+
+func (p *pollCounter) PollHotkey() (rune, bool) {
+	p.polls++
+	return 0, false
+}
+
+// Stepping back replays instructions with the keypad the snapshot
+// recorded, without polling the input again.
+func TestStepBackInput(t *testing.T) {
+	newVM := func() (*interpreter.VirtualMachine, *pollCounter) {
+		vm := newCounterVM(10)
+		source := &pollCounter{Script: input.NewScript(
+			input.ScriptEvent{Frame: 0, Key: 0x5, Pressed: true},
+			input.ScriptEvent{Frame: 2, Key: 0x5, Pressed: false},
+		)}
+		vm.Input = source
+		return vm, source
+	}
+	vm, source := newVM()
+	if _, err := vm.RunCycles(14); err != nil {
+		t.Fatal(err)
+	}
+	polls := source.polls
+	if err := vm.StepBack(9); err != nil {
+		t.Fatal(err)
+	}
+	if source.polls != polls {
+		t.Errorf("the input was polled %d times while stepping back", source.polls-polls)
+	}
+	if vm.Input != input.Source(source) {
+		t.Error("the input was not restored after stepping back")
+	}
+	want, _ := newVM()
+	if _, err := want.RunCycles(5); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saveState(t, vm), saveState(t, want)) {
+		t.Error("state after stepping back differs from running up to the instruction")
+	}
+}
@@ -0,0 +1,168 @@
+package interpreter_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abhinand20/emugo/assembler/asm"
+	common "github.com/abhinand20/emugo/common"
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/input"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+var update = flag.Bool("update", false, "Regenerate the golden frames in testdata/golden.")
+
+const (
+	romTestDir = "../../roms/tests"
+	goldenDir  = "testdata/golden"
+	movieDir   = "testdata/movies"
+)
+
+var romTests = []struct {
+	rom    string
+	quirks string
+	cycles int
+}{
+	{rom: "1-chip8-logo.ch8", quirks: "vip", cycles: 1000},
+	{rom: "2-ibm-logo.ch8", quirks: "vip", cycles: 1000},
+	{rom: "3-corax+.ch8", quirks: "vip", cycles: 5000},
+	{rom: "4-flags.ch8", quirks: "vip", cycles: 5000},
+	{rom: "6-keypad.ch8", quirks: "vip", cycles: 5000},
+}
+
+// runHeadless runs the ROM for a fixed number of cycles and
+// returns the final display.
+func runHeadless(t *testing.T, rom, quirksPreset string, cycles int) *disp.Framebuffer {
+	t.Helper()
+	program, err := common.ReadFile(filepath.Join(romTestDir, rom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quirks, err := interpreter.QuirksPreset(quirksPreset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb := &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth}
+	vm := interpreter.VirtualMachine{Display: fb, Quirks: quirks}
+	if err := vm.Init(program, 700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RunCycles(cycles); err != nil {
+		t.Fatal(err)
+	}
+	return fb
+}
+
+// checkGolden compares the display with the golden frame of the given name.
+func checkGolden(t *testing.T, fb *disp.Framebuffer, name string) {
+	t.Helper()
+	var got bytes.Buffer
+	if err := disp.WritePBM(&got, fb, 1); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join(goldenDir, name+".pbm")
+	if *update {
+		if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("unable to read golden frame, run with -update to create it: %v", err)
+	}
+	if sha256.Sum256(got.Bytes()) != sha256.Sum256(want) {
+		t.Errorf("frame differs from %s, run with -update if this is intended. Got:\n%s", golden, fb.String())
+	}
+}
+
+func TestGoldenFrames(t *testing.T) {
+	for _, tc := range romTests {
+		t.Run(tc.rom, func(t *testing.T) {
+			fb := runHeadless(t, tc.rom, tc.quirks, tc.cycles)
+			checkGolden(t, fb, tc.rom)
+		})
+	}
+}
+
+// TestQuirkPresets runs testdata/quirks.asm, which checks which quirks
+// are emulated, under every preset and compares what it detects with the
+// behaviour of each platform.
+func TestQuirkPresets(t *testing.T) {
+	program, err := asm.AssembleFile("testdata/quirks.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Logic resets VF, load/store moves I, shift uses Vy, jump uses Vx,
+	// sprites are clipped and display waits for vblank
+	want := map[string][6]byte{
+		"legacy": {0, 0, 0, 0, 0, 0},
+		"vip":    {1, 1, 1, 0, 1, 1},
+		"chip48": {0, 0, 0, 1, 1, 0},
+		"schip":  {0, 0, 0, 1, 1, 0},
+		"xochip": {0, 1, 1, 0, 0, 0},
+	}
+	for _, preset := range interpreter.QuirkPresetNames() {
+		t.Run(preset, func(t *testing.T) {
+			quirks, err := interpreter.QuirksPreset(preset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fb := &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth}
+			vm := interpreter.VirtualMachine{Display: fb, Quirks: quirks}
+			if err := vm.Init(program.Bytes, 700); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := vm.RunCycles(1000); err != nil {
+				t.Fatal(err)
+			}
+			results, err := vm.ReadMemory(int(program.Symbols["results"])+1, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, ok := want[preset]; !ok || !bytes.Equal(results, expected[:]) {
+				t.Errorf("detected quirks %v, want %v\n%s", results, expected, fb.String())
+			}
+		})
+	}
+}
+
+// TestMovieReplay replays the movies recorded with -record in
+// testdata/movies, named after their ROM, and checks the final frames.
+func TestMovieReplay(t *testing.T) {
+	movies, err := filepath.Glob(filepath.Join(movieDir, "*.movie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range movies {
+		rom := strings.TrimSuffix(filepath.Base(path), ".movie") + ".ch8"
+		t.Run(rom, func(t *testing.T) {
+			program, err := common.ReadFile(filepath.Join(romTestDir, rom))
+			if err != nil {
+				t.Fatal(err)
+			}
+			movie, err := interpreter.ReadMovie(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if movie.ROM != interpreter.ROMHash(program) {
+				t.Fatalf("%s was recorded with another ROM", path)
+			}
+			fb := &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth}
+			vm := interpreter.VirtualMachine{Display: fb, Quirks: movie.Quirks, MemoryBytes: movie.MemorySize, Seed: movie.Seed, Input: input.NewReplay(movie.Frames)}
+			if err := vm.Init(program, movie.ClockSpeed); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := vm.RunFrames(len(movie.Frames)); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, fb, rom+".movie")
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package interpreter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	disp "github.com/abhinand20/emugo/display"
+)
+
+// Save states start with this magic followed by a version byte, which is
+// bumped whenever the layout below changes.
+const (
+	stateMagic = "C8ST"
+	stateVersion = 1
+)
+
+// savedState is the fixed size part of a save state, written big-endian.
+// It is followed by the memory and then the display, one byte of plane
+// bits per pixel, row by row.
+type savedState struct {
+	V [16]uint8
+	I uint16
+	PC uint16
+	SP uint16
+	Stack [16]uint16
+	DT uint8
+	ST uint8
+	Keypad [16]bool
+	RPL [16]uint8
+	Halted bool
+	AudioPattern [16]uint8
+	Pitch uint8
+	Planes uint8
+	RNG uint64
+	FrameCycles uint32
+	CyclesPerFrame uint32
+	ClockCarry uint32
+	WaitVBlank bool
+	MemorySize uint32
+	Width uint16
+	Height uint16
+}
+
+// SaveState writes the complete VM state, so that LoadState can resume
+// execution from this point.
+func (vm *VirtualMachine) SaveState(w io.Writer) error {
+	width, height := vm.Display.Resolution()
+	state := savedState{
+		V: vm.r,
+		I: vm.i,
+		PC: vm.pc,
+		SP: vm.sp,
+		Stack: vm.stack,
+		DT: vm.dt,
+		ST: vm.ds,
+		Keypad: vm.keypad,
+		RPL: vm.rpl,
+		Halted: vm.halted,
+		AudioPattern: vm.audioPattern,
+		Pitch: vm.pitch,
+		Planes: vm.planes,
+		RNG: vm.rng,
+		FrameCycles: uint32(vm.frameCycles),
+		CyclesPerFrame: uint32(vm.cyclesPerFrame),
+		ClockCarry: uint32(vm.clockCarry),
+		WaitVBlank: vm.waitVBlank,
+		MemorySize: uint32(len(vm.memory)),
+		Width: uint16(width),
+		Height: uint16(height),
+	}
+	grid := make([]byte, 0, width * height)
+	for y := uint32(0); y < height; y++ {
+		for x := uint32(0); x < width; x++ {
+			grid = append(grid, vm.Display.Pixel(x, y))
+		}
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(stateMagic)
+	bw.WriteByte(stateVersion)
+	binary.Write(bw, binary.BigEndian, &state)
+	bw.Write(vm.memory)
+	bw.Write(grid)
+	return bw.Flush()
+}
+
+// LoadState restores a state written by SaveState. The VM must have been
+// initialised with the same amount of memory.
+func (vm *VirtualMachine) LoadState(r io.Reader) error {
+	header := make([]byte, len(stateMagic) + 1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("unable to read save state: %v", err)
+	}
+	if string(header[:len(stateMagic)]) != stateMagic {
+		return fmt.Errorf("not a save state")
+	}
+	if version := header[len(stateMagic)]; version != stateVersion {
+		return fmt.Errorf("unsupported save state version %d, expected %d", version, stateVersion)
+	}
+	var state savedState
+	if err := binary.Read(r, binary.BigEndian, &state); err != nil {
+		return fmt.Errorf("unable to read save state: %v", err)
+	}
+	if int(state.MemorySize) != len(vm.memory) {
+		return fmt.Errorf("save state has %d bytes of memory, the VM %d", state.MemorySize, len(vm.memory))
+	}
+	if int(state.SP) >= len(vm.stack) {
+		return fmt.Errorf("save state has an invalid stack pointer %d", state.SP)
+	}
+	// Only the 2 XO-CHIP bit-planes can be selected
+	if state.Planes > 3 {
+		return fmt.Errorf("save state has invalid planes %d", state.Planes)
+	}
+	lores := state.Width == disp.LoresWidth && state.Height == disp.LoresHeight
+	hires := state.Width == disp.HiresWidth && state.Height == disp.HiresHeight
+	if !lores && !hires {
+		return fmt.Errorf("save state has an invalid resolution %dx%d", state.Width, state.Height)
+	}
+	memory := make([]byte, state.MemorySize)
+	grid := make([]byte, int(state.Width) * int(state.Height))
+	if _, err := io.ReadFull(r, memory); err != nil {
+		return fmt.Errorf("unable to read save state memory: %v", err)
+	}
+	if _, err := io.ReadFull(r, grid); err != nil {
+		return fmt.Errorf("unable to read save state display: %v", err)
+	}
+	copy(vm.memory, memory)
+	vm.r = state.V
+	vm.i = state.I
+	vm.pc = state.PC
+	vm.sp = state.SP
+	vm.stack = state.Stack
+	vm.dt = state.DT
+	vm.ds = state.ST
+	vm.keypad = state.Keypad
+	vm.rpl = state.RPL
+	vm.halted = state.Halted
+	vm.audioPattern = state.AudioPattern
+	vm.pitch = state.Pitch
+	vm.planes = state.Planes
+	vm.rng = state.RNG
+	vm.frameCycles = int(state.FrameCycles)
+	vm.cyclesPerFrame = max(1, int(state.CyclesPerFrame))
+	vm.clockCarry = int(state.ClockCarry)
+	vm.waitVBlank = state.WaitVBlank
+	width, height := uint32(state.Width), uint32(state.Height)
+	vm.Display.SetResolution(width, height)
+	for y := uint32(0); y < height; y++ {
+		for x := uint32(0); x < width; x++ {
+			vm.Display.SetPixel(x, y, grid[y * width + x])
+		}
+	}
+	vm.Display.SetPlanes(vm.planes)
+	vm.displayDirty = true
+	return nil
+}
+
+// SaveStateFile writes the VM state to a file.
+func (vm *VirtualMachine) SaveStateFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create save state '%s': %v", path, err)
+	}
+	if err := vm.SaveState(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write save state '%s': %v", path, err)
+	}
+	return f.Close()
+}
+
+// LoadStateFile restores the VM state from a file.
+func (vm *VirtualMachine) LoadStateFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open save state '%s': %v", path, err)
+	}
+	defer f.Close()
+	if err := vm.LoadState(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("'%s': %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package interpreter_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+// Switches to high resolution, draws and keeps calling a subroutine
+// changing registers, the stack, the timers and the random state
+var stateProgram = []byte{
+	0x00, 0xFF, // 200: HIGH
+	0x60, 0x07, // 202: LD V0, 0x07
+	0xF0, 0x29, // 204: LD F, V0
+	0x61, 0x05, // 206: LD V1, 0x05
+	0xD0, 0x15, // 208: DRW V0, V1, 5
+	0xC2, 0xFF, // 20A: RND V2, 0xFF
+	0x22, 0x10, // 20C: CALL 0x210
+	0x12, 0x0A, // 20E: JP 0x20A
+	0x71, 0x01, // 210: ADD V1, 0x01
+	0xF2, 0x15, // 212: LD DT, V2
+	0xD0, 0x15, // 214: DRW V0, V1, 5
+	0x00, 0xEE, // 216: RET
+}
+
+func newStateVM(t *testing.T, memoryBytes int) *interpreter.VirtualMachine {
+	t.Helper()
+	vm := &interpreter.VirtualMachine{
+		Display:     &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+		MemoryBytes: memoryBytes,
+		Seed:        42,
+	}
+	// 700Hz leaves the clock carry and frame position mid-way
+	if err := vm.Init(stateProgram, 700); err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func saveState(t *testing.T, vm *interpreter.VirtualMachine) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := vm.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	vm := newStateVM(t, 0)
+	if _, err := vm.RunCycles(100); err != nil {
+		t.Fatal(err)
+	}
+	saved := saveState(t, vm)
+
+	// Another VM, which ran elsewhere, picks up from the save state
+	other := newStateVM(t, 0)
+	if _, err := other.RunCycles(37); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.LoadState(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saveState(t, other), saved) {
+		t.Fatal("saving the loaded state gives a different state")
+	}
+	if got, want := other.Display.(*disp.Framebuffer).String(), vm.Display.(*disp.Framebuffer).String(); got != want {
+		t.Errorf("loaded display:\n%s\nwant:\n%s", got, want)
+	}
+	if width, height := other.Display.Resolution(); width != disp.HiresWidth || height != disp.HiresHeight {
+		t.Errorf("loaded resolution %dx%d, want 128x64", width, height)
+	}
+	// Both carry on identically, random numbers and timers included
+	for _, v := range []*interpreter.VirtualMachine{vm, other} {
+		if _, err := v.RunCycles(500); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(saveState(t, other), saveState(t, vm)) {
+		t.Error("VMs diverged after loading the state")
+	}
+}
+
+func TestSaveStateFile(t *testing.T) {
+	vm := newStateVM(t, 0)
+	if _, err := vm.RunCycles(100); err != nil {
+		t.Fatal(err)
+	}
+	path := t.TempDir() + "/game.state"
+	if err := vm.SaveStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	other := newStateVM(t, 0)
+	if err := other.LoadStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if other.Registers() != vm.Registers() {
+		t.Errorf("loaded registers %+v, want %+v", other.Registers(), vm.Registers())
+	}
+	if err := other.LoadStateFile(path + ".missing"); err == nil || !strings.HasPrefix(err.Error(), "unable to open save state") {
+		t.Errorf("loading a missing file gave %v", err)
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	vm := newStateVM(t, 0)
+	saved := saveState(t, vm)
+	// The fixed size part ends with the memory size and the resolution,
+	// followed by the memory and the 64x32 display
+	resolution := len(saved) - interpreter.DefaultMemorySize - disp.LoresWidth*disp.LoresHeight - 4
+	// The planes come before the 25 bytes of the generator, cycle counters,
+	// vblank wait and memory size
+	planes := resolution - 26
+	patched := func(offset int, data ...byte) []byte {
+		state := append([]byte{}, saved...)
+		copy(state[offset:], data)
+		return state
+	}
+	tests := []struct {
+		name  string
+		state []byte
+		// Memory of the VM loading the state
+		memoryBytes int
+		want        string
+	}{
+		{name: "empty", state: nil, want: "unable to read save state: EOF"},
+		{name: "bad magic", state: patched(0, 'C', '8', 'T', 'R'), want: "not a save state"},
+		{name: "old version", state: patched(4, 0), want: "unsupported save state version 0, expected 1"},
+		{name: "newer version", state: patched(4, 2), want: "unsupported save state version 2, expected 1"},
+		{name: "memory size", state: saved, memoryBytes: interpreter.XOChipMemorySize, want: "save state has 4096 bytes of memory, the VM 65536"},
+		{name: "planes", state: patched(planes, 4), want: "save state has invalid planes 4"},
+		{name: "huge planes", state: patched(planes, 0xFF), want: "save state has invalid planes 255"},
+		{name: "resolution", state: patched(resolution, 0, 64, 0, 64), want: "save state has an invalid resolution 64x64"},
+		{name: "huge resolution", state: patched(resolution, 0xFF, 0xFF, 0xFF, 0xFF), want: "save state has an invalid resolution 65535x65535"},
+		{name: "truncated header", state: saved[:20], want: "unable to read save state: unexpected EOF"},
+		{name: "truncated memory", state: saved[:resolution+100], want: "unable to read save state memory: unexpected EOF"},
+		{name: "truncated display", state: saved[:len(saved)-1], want: "unable to read save state display: unexpected EOF"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			target := newStateVM(t, tc.memoryBytes)
+			before := saveState(t, target)
+			err := target.LoadState(bytes.NewReader(tc.state))
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("got error %v, want %q", err, tc.want)
+			}
+			// Failed loads leave the VM alone
+			if !bytes.Equal(saveState(t, target), before) {
+				t.Error("a failed load changed the VM")
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package interpreter
+
+import (
+	"fmt"
+)
+
+// Registers is a copy of the CPU state, for debuggers.
+type Registers struct {
+	V [16]uint8
+	I uint16
+	PC uint16
+	SP uint16
+	DT uint8
+	ST uint8
+	// Return addresses, Stack[1] to Stack[SP] are in use
+	Stack [16]uint16
+}
+
+// Registers returns the current CPU state.
+func (vm *VirtualMachine) Registers() Registers {
+	return Registers{
+		V: vm.r,
+		I: vm.i,
+		PC: vm.pc,
+		SP: vm.sp,
+		DT: vm.dt,
+		ST: vm.ds,
+		Stack: vm.stack,
+	}
+}
+
+// SetRegisters replaces the CPU state.
+func (vm *VirtualMachine) SetRegisters(regs Registers) error {
+	if int(regs.SP) >= len(vm.stack) {
+		return fmt.Errorf("stack pointer %d out of range", regs.SP)
+	}
+	vm.r = regs.V
+	vm.i = regs.I
+	vm.pc = regs.PC
+	vm.sp = regs.SP
+	vm.dt = regs.DT
+	vm.ds = regs.ST
+	vm.stack = regs.Stack
+	return nil
+}
+
+// MemorySize is the size of the VM's address space.
+func (vm *VirtualMachine) MemorySize() int {
+	return len(vm.memory)
+}
+
+// ReadMemory copies n bytes from addr.
+func (vm *VirtualMachine) ReadMemory(addr, n int) ([]byte, error) {
+	if addr < 0 || n < 0 || addr + n > len(vm.memory) {
+		return nil, fmt.Errorf("memory range 0x%X-0x%X out of bounds", addr, addr + n)
+	}
+	return append([]byte{}, vm.memory[addr:addr + n]...), nil
+}
+
+// WriteMemory copies data to memory starting at addr.
+func (vm *VirtualMachine) WriteMemory(addr int, data []byte) error {
+	if addr < 0 || addr + len(data) > len(vm.memory) {
+		return fmt.Errorf("memory range 0x%X-0x%X out of bounds", addr, addr + len(data))
+	}
+	copy(vm.memory[addr:], data)
+	return nil
+}
@@ -0,0 +1,129 @@
+; Quirks check for the quirk presets, written for our own tests. It is not
+; the Timendus quirks test. TestQuirkPresets assembles it, runs it under
+; every preset and reads the results byte of each test, 1 when the quirk
+; was seen and 0 when it was not:
+;   1 logic resets VF        4 jump uses Vx
+;   2 load/store moves I     5 sprites are clipped
+;   3 shift uses Vy          6 display waits for vblank
+; Each row on screen shows the test number followed by its result.
+
+start:
+	CLS
+	LD VB, 1	; number of the current test
+	LD VC, 2	; position of the current row
+	LD VD, 8
+
+; 1: OR after setting VF
+	LD VF, 5
+	LD V1, 1
+	LD V2, 2
+	OR V1, V2
+	LD VA, 0
+	SNE VF, 0
+	LD VA, 1
+	CALL show
+
+; 2: store two registers, then load V0 from where I points
+	LD V0, 0
+	LD V1, 0
+	LD I, membuf
+	LD [I], V1
+	LD V0, [I]
+	LD VA, V0
+	CALL show
+
+; 3: shift V1 by taking V2
+	LD V1, 1
+	LD V2, 4
+	SHR V1, V2
+	LD VA, 0
+	SNE V1, 2
+	LD VA, 1
+	CALL show
+
+; 4: jump with V0 = 0 and V2 = 2, Bnnn using V2 landing one instruction
+; further. jumptarget must be within 0x200-0x2FF for this
+	LD V0, 0
+	LD V2, 2
+	JP V0, jumptarget
+jumptarget:
+	JP jump_off
+	JP jump_on
+jump_off:
+	LD VA, 0
+	JP jump_done
+jump_on:
+	LD VA, 1
+jump_done:
+	CALL show
+
+; 5: draw a line running off the right edge, then check whether a dot at
+; the left edge collides with its wrapped part. Both are drawn again to
+; erase them
+	LD V1, 60
+	LD V2, 31
+	LD V3, 0
+	LD I, line
+	DRW V1, V2, 1
+	LD I, dot
+	DRW V3, V2, 1
+	LD VA, VF
+	LD V4, 1
+	XOR VA, V4
+	DRW V3, V2, 1
+	LD I, line
+	DRW V1, V2, 1
+	CALL show
+
+; 6: draw 10 times, which takes 10 frames when waiting for vblank and
+; less than 5 otherwise
+	LD V1, 60
+	LD DT, V1
+	LD V1, 0
+	LD V2, 31
+	LD V5, 10
+	LD I, dot
+wait_loop:
+	DRW V1, V2, 1
+	ADD V5, 0xFF
+	SE V5, 0
+	JP wait_loop
+	LD V1, DT
+	LD V3, 53
+	SUB V3, V1
+	LD VA, VF
+	CALL show
+
+end:
+	JP end
+
+; show records the result VA of test VB, draws both at VD, VC, then moves
+; on to the next row
+show:
+	LD I, results
+	ADD I, VB
+	LD V0, VA
+	LD [I], V0
+	LD F, VB
+	DRW VD, VC, 5
+	LD V0, VD
+	ADD V0, 8
+	LD F, VA
+	DRW V0, VC, 5
+	ADD VB, 1
+	ADD VC, 10
+	SE VC, 32
+	RET
+	LD VC, 2
+	LD VD, 40
+	RET
+
+line:
+	db 0xFF
+dot:
+	db 0x80
+membuf:
+	db 0x00, 0x00, 0x01
+; Byte n is the result of test n, byte 0 is unused
+results:
+	db 0, 0, 0, 0, 0, 0, 0
@@ -0,0 +1,199 @@
+package interpreter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	common "github.com/abhinand20/emugo/common"
+)
+
+// Trace formats, picked from the file extension
+const (
+	TraceText = iota
+	TraceJSONL
+	TraceBinary
+)
+
+// Binary traces start with this magic followed by a version byte
+const (
+	traceMagic = "C8TR"
+	traceVersion = 1
+)
+
+// Bits of the change mask of binary trace records, V0-VF are bits 0-15
+const (
+	traceI = 1 << (16 + iota)
+	traceSP
+	traceDT
+	traceST
+)
+
+// Tracer logs every executed instruction with the registers it changed.
+//
+// Text traces have one line per instruction: the cycle, the listing line
+// of the instruction and its changes, e.g.
+//
+//	42 0206: 7301 ADD V3, 0x01  V3=0x00->0x01
+//
+// JSONL traces have one object per instruction, with the registers that
+// changed mapped to their new values:
+//
+//	{"cycle":42,"pc":518,"opcode":29441,"inst":"ADD V3, 0x01","changes":{"V3":1}}
+//
+// Binary traces are "C8TR", a version byte and then one record per
+// instruction, big-endian: PC (2 bytes), opcode (2), a change mask (4) and
+// the new value of each changed register in mask order, V0-VF, I (2), SP,
+// DT and ST (1 each). The cycle is the index of the record.
+type Tracer struct {
+	w *bufio.Writer
+	file io.Closer
+	format int
+	cycle uint64
+	err error
+}
+
+// TraceFormat returns the format of a trace file from its extension,
+// .jsonl or .bin, defaulting to text.
+func TraceFormat(path string) int {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl": return TraceJSONL
+	case ".bin": return TraceBinary
+	}
+	return TraceText
+}
+
+// CreateTrace creates a trace file in the format of its extension.
+func CreateTrace(path string) (*Tracer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create trace '%s': %v", path, err)
+	}
+	t := NewTracer(f, TraceFormat(path))
+	t.file = f
+	return t, nil
+}
+
+// NewTracer writes a trace in the given format to w.
+func NewTracer(w io.Writer, format int) *Tracer {
+	t := &Tracer{w: bufio.NewWriter(w), format: format}
+	if format == TraceBinary {
+		t.w.WriteString(traceMagic)
+		t.w.WriteByte(traceVersion)
+	}
+	return t
+}
+
+// Close flushes the trace and reports the first error writing it.
+func (t *Tracer) Close() error {
+	if err := t.w.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+	if t.file != nil {
+		if err := t.file.Close(); err != nil && t.err == nil {
+			t.err = err
+		}
+	}
+	if t.err != nil {
+		return fmt.Errorf("unable to write trace: %v", t.err)
+	}
+	return nil
+}
+
+// traceChange is a register that an instruction changed.
+type traceChange struct {
+	name string
+	bit uint32
+	from int
+	to int
+	// Size of the value in binary records
+	size int
+}
+
+func traceChanges(before, after Registers) []traceChange {
+	var changes []traceChange
+	for idx := range before.V {
+		if before.V[idx] != after.V[idx] {
+			changes = append(changes, traceChange{fmt.Sprintf("V%X", idx), 1 << idx, int(before.V[idx]), int(after.V[idx]), 1})
+		}
+	}
+	if before.I != after.I {
+		changes = append(changes, traceChange{"I", traceI, int(before.I), int(after.I), 2})
+	}
+	if before.SP != after.SP {
+		changes = append(changes, traceChange{"SP", traceSP, int(before.SP), int(after.SP), 1})
+	}
+	if before.DT != after.DT {
+		changes = append(changes, traceChange{"DT", traceDT, int(before.DT), int(after.DT), 1})
+	}
+	if before.ST != after.ST {
+		changes = append(changes, traceChange{"ST", traceST, int(before.ST), int(after.ST), 1})
+	}
+	return changes
+}
+
+// record logs an instruction given the registers before and after it ran.
+func (t *Tracer) record(inst common.Instruction, before, after Registers) {
+	if t.err != nil {
+		return
+	}
+	t.cycle++
+	changes := traceChanges(before, after)
+	switch t.format {
+	case TraceText:
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d %s", t.cycle, inst.Line())
+		for idx, c := range changes {
+			sep := " "
+			if idx == 0 {
+				sep = "  "
+			}
+			if c.size == 2 {
+				fmt.Fprintf(&b, "%s%s=0x%03X->0x%03X", sep, c.name, c.from, c.to)
+			} else {
+				fmt.Fprintf(&b, "%s%s=0x%02X->0x%02X", sep, c.name, c.from, c.to)
+			}
+		}
+		b.WriteByte('\n')
+		_, t.err = t.w.WriteString(b.String())
+	case TraceJSONL:
+		values := make(map[string]int)
+		for _, c := range changes {
+			values[c.name] = c.to
+		}
+		line, err := json.Marshal(struct {
+			Cycle uint64 `json:"cycle"`
+			PC uint16 `json:"pc"`
+			Opcode uint16 `json:"opcode"`
+			Inst string `json:"inst"`
+			Changes map[string]int `json:"changes"`
+		}{t.cycle, inst.Address, inst.Opcode, inst.String(), values})
+		if err != nil {
+			t.err = err
+			return
+		}
+		t.w.Write(line)
+		t.err = t.w.WriteByte('\n')
+	case TraceBinary:
+		var mask uint32
+		for _, c := range changes {
+			mask |= c.bit
+		}
+		record := binary.BigEndian.AppendUint16(nil, inst.Address)
+		record = binary.BigEndian.AppendUint16(record, inst.Opcode)
+		record = binary.BigEndian.AppendUint32(record, mask)
+		for _, c := range changes {
+			if c.size == 2 {
+				record = binary.BigEndian.AppendUint16(record, uint16(c.to))
+			} else {
+				record = append(record, byte(c.to))
+			}
+		}
+		_, t.err = t.w.Write(record)
+	}
+}
@@ -0,0 +1,77 @@
+package interpreter_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/interpreter"
+)
+
+// Changes every kind of register the traces record
+var traceProgram = []byte{
+	0x60, 0x05, // 200: LD V0, 0x05
+	0xA3, 0x00, // 202: LD I, 0x300
+	0xF0, 0x15, // 204: LD DT, V0
+	0xF0, 0x18, // 206: LD ST, V0
+	0x22, 0x0E, // 208: CALL 0x20E
+	0x70, 0x01, // 20A: ADD V0, 0x01
+	0x12, 0x0C, // 20C: JP 0x20C
+	0x6F, 0x01, // 20E: LD VF, 0x01
+	0x00, 0xEE, // 210: RET
+}
+
+// traceRun traces the first instructions of the trace program.
+func traceRun(t *testing.T, format int) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	vm := interpreter.VirtualMachine{
+		Display: &disp.Framebuffer{Height: disp.LoresHeight, Width: disp.LoresWidth},
+		Tracer:  interpreter.NewTracer(&out, format),
+	}
+	if err := vm.Init(traceProgram, 700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.RunCycles(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestTraceFormats(t *testing.T) {
+	tests := []struct {
+		file   string
+		format int
+	}{
+		{"trace.txt", interpreter.TraceText},
+		{"trace.jsonl", interpreter.TraceJSONL},
+		{"trace.bin", interpreter.TraceBinary},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			if format := interpreter.TraceFormat(tc.file); format != tc.format {
+				t.Errorf("TraceFormat(%q) = %d, want %d", tc.file, format, tc.format)
+			}
+			got := traceRun(t, tc.format)
+			golden := filepath.Join(goldenDir, tc.file)
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unable to read golden trace, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("trace differs from %s, run with -update if this is intended. Got:\n%q", golden, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of watchpoint, memory watches may combine read and write
+const (
+	watchRead = 1 << iota
+	watchWrite
+	// Stops when the value of an expression changes
+	watchChange
+	// Stops when an expression becomes true
+	watchWhen
+)
+
+type watchpoint struct {
+	kind int
+	// Memory range [start, end) of read and write watches
+	start int
+	end int
+	// Expression of change and when watches, with its last value
+	cond expr
+	text string
+	last int
+}
+
+func (w *watchpoint) String() string {
+	switch w.kind {
+	case watchChange: return fmt.Sprintf("change of %s", w.text)
+	case watchWhen: return fmt.Sprintf("when %s", w.text)
+	}
+	access := map[int]string{watchRead: "read", watchWrite: "write", watchRead | watchWrite: "access"}[w.kind]
+	if w.end - w.start == 1 {
+		return fmt.Sprintf("%s of 0x%03X", access, w.start)
+	}
+	return fmt.Sprintf("%s of 0x%03X-0x%03X", access, w.start, w.end - 1)
+}
+
+// addMemoryWatch handles "watch ADDR [N]", "rwatch" and "awatch".
+func (d *Debugger) addMemoryWatch(kind int, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: watch ADDR [N]")
+	}
+	addr, err := parseValue(args[0], len(d.vm.memory) - 1)
+	if err != nil {
+		return err
+	}
+	n := 1
+	if len(args) == 2 {
+		if n, err = parseValue(args[1], len(d.vm.memory) - addr); err != nil || n == 0 {
+			return fmt.Errorf("invalid length '%s'", args[1])
+		}
+	}
+	return d.addWatch(&watchpoint{kind: kind, start: addr, end: addr + n})
+}
+
+// addExprWatch handles "watch EXPR" and "when EXPR".
+func (d *Debugger) addExprWatch(kind int, args []string) error {
+	text := strings.Join(args, " ")
+	cond, err := parseExpr(text)
+	if err != nil {
+		return err
+	}
+	w := &watchpoint{kind: kind, cond: cond, text: text, last: cond(d.vm)}
+	if kind == watchWhen {
+		w.last = exprBool(w.last != 0)
+	}
+	return d.addWatch(w)
+}
+
+func (d *Debugger) addWatch(w *watchpoint) error {
+	d.watches = append(d.watches, w)
+	fmt.Fprintf(d.out, "Watchpoint %d: %s\n", len(d.watches), w)
+	return nil
+}
+
+func (d *Debugger) removeWatch(args []string) error {
+	if len(args) == 0 {
+		d.watches = nil
+		return nil
+	}
+	n, err := parseValue(args[0], len(d.watches))
+	if err != nil || n == 0 {
+		return fmt.Errorf("no watchpoint %s", args[0])
+	}
+	d.watches = append(d.watches[:n - 1], d.watches[n:]...)
+	return nil
+}
+
+func (d *Debugger) printWatches() {
+	if len(d.watches) == 0 {
+		fmt.Fprintln(d.out, "No watchpoints")
+		return
+	}
+	for idx, w := range d.watches {
+		fmt.Fprintf(d.out, "%d: %s\n", idx + 1, w)
+	}
+}
+
+// memoryAccess is called by the VM for each data access of an
+// instruction, recording the watchpoints it hits.
+func (d *Debugger) memoryAccess(addr int, value byte, write bool) {
+	for idx, w := range d.watches {
+		if addr < w.start || addr >= w.end {
+			continue
+		}
+		if write && w.kind & watchWrite != 0 {
+			d.hits = append(d.hits, fmt.Sprintf("Watchpoint %d: 0x%03X written with 0x%02X at 0x%03X", idx + 1, addr, value, d.lastPC))
+		} else if !write && w.kind & watchRead != 0 {
+			d.hits = append(d.hits, fmt.Sprintf("Watchpoint %d: 0x%03X read 0x%02X at 0x%03X", idx + 1, addr, value, d.lastPC))
+		}
+	}
+}
+
+// checkExprWatches evaluates the expression watches after an
+// instruction, recording those that changed or became true.
+func (d *Debugger) checkExprWatches() {
+	for idx, w := range d.watches {
+		switch w.kind {
+		case watchChange:
+			value := w.cond(d.vm)
+			if value != w.last {
+				d.hits = append(d.hits, fmt.Sprintf("Watchpoint %d: %s changed from 0x%X to 0x%X at 0x%03X", idx + 1, w.text, w.last, value, d.lastPC))
+			}
+			w.last = value
+		case watchWhen:
+			value := exprBool(w.cond(d.vm) != 0)
+			if value == 1 && w.last == 0 {
+				d.hits = append(d.hits, fmt.Sprintf("Watchpoint %d: %s became true at 0x%03X", idx + 1, w.text, d.lastPC))
+			}
+			w.last = value
+		}
+	}
+}
+
+// refreshExprWatches takes the current value of the expression watches
+// after the VM state was replaced, so that they do not trigger on it.
+func (d *Debugger) refreshExprWatches() {
+	for _, w := range d.watches {
+		switch w.kind {
+		case watchChange: w.last = w.cond(d.vm)
+		case watchWhen: w.last = exprBool(w.cond(d.vm) != 0)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package interpreter_test
+
+import "testing"
+
+// Writes then reads 0x300
+var memoryProgram = []byte{
+	0xA3, 0x00, // 200: LD I, 0x300
+	0x60, 0x42, // 202: LD V0, 0x42
+	0xF0, 0x55, // 204: LD [I], V0
+	0xF0, 0x65, // 206: LD V0, [I]
+	0x63, 0x10, // 208: LD V3, 0x10
+	0x12, 0x0A, // 20A: JP 0x20A
+}
+
+func TestWatchpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     []string
+		pc       uint16
+	}{
+		{
+			name:     "write",
+			commands: []string{"watch 0x300", "c"},
+			want:     []string{"Watchpoint 1: write of 0x300", "Watchpoint 1: 0x300 written with 0x42 at 0x204", "> 0206:"},
+			pc:       0x206,
+		},
+		{
+			name:     "read",
+			commands: []string{"rwatch 0x2FF 2", "c"},
+			want:     []string{"Watchpoint 1: read of 0x2FF-0x300", "Watchpoint 1: 0x300 read 0x42 at 0x206", "> 0208:"},
+			pc:       0x208,
+		},
+		{
+			name:     "access",
+			commands: []string{"awatch 0x300", "c", "c"},
+			want:     []string{"Watchpoint 1: access of 0x300", "written with 0x42 at 0x204", "read 0x42 at 0x206"},
+			pc:       0x208,
+		},
+		{
+			name:     "outside the range",
+			commands: []string{"watch 0x301 4", "rwatch 0x2F0 0x10", "break 0x20A", "c"},
+			want:     []string{"Watchpoint 1: write of 0x301-0x304", "Watchpoint 2: read of 0x2F0-0x2FF", "Breakpoint at 0x20A"},
+			pc:       0x20A,
+		},
+		{
+			name:     "expression change",
+			commands: []string{"watch V0", "c"},
+			want:     []string{"Watchpoint 1: change of V0", "V0 changed from 0x0 to 0x42 at 0x202", "> 0204:"},
+			pc:       0x204,
+		},
+		{
+			name:     "when",
+			commands: []string{"when V3 > 8 && [0x300] == 0x42", "c"},
+			want:     []string{"Watchpoint 1: when V3 > 8 && [0x300] == 0x42", "became true at 0x208", "> 020A:"},
+			pc:       0x20A,
+		},
+		{
+			name:     "unwatch",
+			commands: []string{"watch 0x300", "watch V0", "unwatch 1", "bl", "unwatch 3", "unwatch", "bl", "break 0x20A", "c"},
+			want:     []string{"1: change of V0\n", "err: no watchpoint 3", "No watchpoints", "Breakpoint at 0x20A"},
+			pc:       0x20A,
+		},
+		{
+			name:     "invalid",
+			commands: []string{"watch 0x300 0", "rwatch", "watch 0x10000", "when"},
+			want:     []string{"err: invalid length '0'", "err: usage: watch ADDR [N]", "err: invalid value '0x10000'", "err: usage: when EXPR"},
+			pc:       0x200,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vm, out := runDebugger(t, memoryProgram, tc.commands...)
+			checkOutput(t, out, tc.want)
+			if pc := vm.Registers().PC; pc != tc.pc {
+				t.Errorf("PC = 0x%03X, want 0x%03X", pc, tc.pc)
+			}
+		})
+	}
+}
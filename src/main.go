@@ -3,9 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	common "github.com/abhinand20/emugo/common"
+	"github.com/abhinand20/emugo/dap"
 	disp "github.com/abhinand20/emugo/display"
+	"github.com/abhinand20/emugo/gdb"
 	"github.com/abhinand20/emugo/input"
 	"github.com/abhinand20/emugo/interpreter"
 )
@@ -13,20 +19,125 @@ import (
 var inputFile string
 var clkSpeed int
 var debug bool
+var quirksPreset string
+var headless bool
+var frames int
+var snapshotFile string
+var snapshotScale int
+var paletteColours string
+var gdbAddr string
+var dapAddr string
+var traceFile string
+var errorPolicy string
+var loadStateFile string
+var rewindSeconds int
+var recordFile string
+var replayFile string
+var seed uint64
+var inputSource string
+var evdev bool
 
 func initFlags() {
 	flag.StringVar(&inputFile, "file", "", "File containing CHIP-8 hex code.")
-	flag.IntVar(&clkSpeed, "clock_speed", 700, "Clock speed of the emulator in Hz.")
+	flag.IntVar(&clkSpeed, "clock_speed", 700, "Clock speed of the emulator in Hz. Speeds that are not a multiple of 60 spread the extra instructions over the frames.")
 	flag.BoolVar(&debug, "debug", false, "Run debugger.")
+	flag.StringVar(&gdbAddr, "gdb", "", "Wait for a GDB remote protocol client on this address, e.g. :1234, and let it drive the VM.")
+	flag.StringVar(&dapAddr, "dap", "", "Wait for a Debug Adapter Protocol client on this address, e.g. :4711, debugging against a listing written next to the file.")
+	flag.StringVar(&traceFile, "trace", "", "Log every executed instruction to this file, as text, or as JSON lines or binary records for .jsonl and .bin files.")
+	flag.StringVar(&loadStateFile, "load-state", "", "Resume from a save state, written with the 'k' hotkey while playing.")
+	flag.IntVar(&rewindSeconds, "rewind_seconds", 300, "Seconds of history kept for rewinding with the 'r' hotkey and the debugger, 0 to disable.")
+	flag.StringVar(&recordFile, "record", "", "Record the keypad state of every frame to this movie file.")
+	flag.StringVar(&replayFile, "replay", "", "Replay a movie recorded with -record instead of reading the keyboard, using its seed, clock speed, quirks and error policy.")
+	flag.StringVar(&inputSource, "input", "keyboard", "Where the keypad state comes from: keyboard, script:FILE with \"FRAME KEY down|up\" lines, or net:ADDR to accept controllers over TCP.")
+	flag.BoolVar(&evdev, "evdev", false, "On Linux, read key releases from the keyboard devices in /dev/input when the terminal does not support the kitty keyboard protocol. They also see keys typed into other windows.")
+	flag.Uint64Var(&seed, "seed", 0, "Seed of the random number generator.")
+	flag.BoolVar(&headless, "headless", false, "Run without a terminal for a fixed number of frames, then print the final display.")
+	flag.IntVar(&frames, "frames", 600, "Number of 60Hz frames to run in headless mode.")
+	flag.StringVar(&snapshotFile, "snapshot", "", "In headless mode, save the final display to this .png or .pbm file.")
+	flag.IntVar(&snapshotScale, "snapshot_scale", 8, "Size in image pixels of each display pixel in snapshots.")
+	flag.StringVar(&paletteColours, "palette", "", "Comma separated RRGGBB snapshot colours for the off, plane 1, plane 2 and both planes pixels.")
+	flag.StringVar(&errorPolicy, "on_error", "halt", fmt.Sprintf("What to do when an instruction overflows the stack, accesses memory out of bounds, checks an invalid key or does not decode, one of: %s.", strings.Join(interpreter.ErrorPolicyNames(), ", ")))
+	flag.StringVar(&quirksPreset, "quirks", "legacy", fmt.Sprintf("Platform quirks to emulate, one of: %s. legacy keeps the behaviour of earlier versions.", strings.Join(interpreter.QuirkPresetNames(), ", ")))
 }
 
 func validateFlags() error {
 	if len(inputFile) == 0 {
 		return fmt.Errorf("input file not provided")
 	}
+	if debug && len(gdbAddr) > 0 || debug && len(dapAddr) > 0 || len(gdbAddr) > 0 && len(dapAddr) > 0 {
+		return fmt.Errorf("only one of debug, gdb and dap can be used")
+	}
+	if len(recordFile) > 0 && len(replayFile) > 0 {
+		return fmt.Errorf("record and replay cannot be used together")
+	}
+	// Debuggers change registers and memory outside the movie
+	if len(recordFile) > 0 && (debug || len(gdbAddr) > 0 || len(dapAddr) > 0) {
+		return fmt.Errorf("record cannot be used with debug, gdb or dap")
+	}
+	if inputSource != "keyboard" && !strings.HasPrefix(inputSource, "script:") && !strings.HasPrefix(inputSource, "net:") {
+		return fmt.Errorf("unknown input '%s', expected keyboard, script:FILE or net:ADDR", inputSource)
+	}
+	if len(replayFile) > 0 && inputSource != "keyboard" {
+		return fmt.Errorf("replays cannot take another input")
+	}
+	if len(loadStateFile) > 0 && (len(recordFile) > 0 || len(replayFile) > 0) {
+		return fmt.Errorf("movies cannot start from a save state")
+	}
+	if rewindSeconds < 0 {
+		return fmt.Errorf("rewind_seconds cannot be negative")
+	}
+	if headless && frames <= 0 {
+		return fmt.Errorf("frames must be positive in headless mode")
+	}
+	if _, err := interpreter.QuirksPreset(quirksPreset); err != nil {
+		return err
+	}
+	if _, err := interpreter.ParseErrorPolicy(errorPolicy); err != nil {
+		return err
+	}
+	if _, err := disp.ParsePalette(paletteColours); err != nil {
+		return err
+	}
 	return nil
 }
 
+// openInput creates the keypad source given with -input. The terminal
+// keyboard is only used when the terminal is free for it.
+func openInput(terminal bool) (input.Source, error) {
+	kind, arg, _ := strings.Cut(inputSource, ":")
+	switch kind {
+	case "script":
+		f, err := os.Open(arg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open input script '%s': %v", arg, err)
+		}
+		defer f.Close()
+		events, err := input.ParseScript(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read input script '%s': %v", arg, err)
+		}
+		return input.NewScript(events...), nil
+	case "net":
+		network, err := input.ListenNetwork(arg)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Waiting for controllers on %s\n", network.Addr())
+		return network, nil
+	}
+	if !terminal {
+		return nil, nil
+	}
+	return &input.Keyboard{Evdev: evdev}, nil
+}
+
+// loadState restores the state given with -load-state, if any.
+func loadState(vm *interpreter.VirtualMachine) error {
+	if len(loadStateFile) == 0 {
+		return nil
+	}
+	return vm.LoadStateFile(loadStateFile)
+}
 
 func main() {
 	initFlags()
@@ -40,22 +151,178 @@ func main() {
 		fmt.Printf("err: %v\n", err)
 		return
 	}
-	d := &disp.TerminalDisplay{
-		Height: 32,
-		Width: 64,
+	fmt.Printf("Read %d bytes\n", len(content))
+	fb := &disp.Framebuffer{
+		Height: disp.LoresHeight,
+		Width: disp.LoresWidth,
 	}
-	d.Init()
-	kb := &input.Keyboard{}
+	quirks, _ := interpreter.QuirksPreset(quirksPreset)
+	palette, _ := disp.ParsePalette(paletteColours)
+	onError, _ := interpreter.ParseErrorPolicy(errorPolicy)
 	vm := interpreter.VirtualMachine{
-		Display: d,
-		Keyboard: kb,
+		Display: fb,
+		Quirks: quirks,
+		MemoryBytes: interpreter.PresetMemorySize(quirksPreset),
+		OnError: onError,
 		Debug: debug,
+		Seed: seed,
+	}
+	if len(replayFile) > 0 {
+		movie, err := interpreter.ReadMovie(replayFile)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return
+		}
+		if movie.ROM != interpreter.ROMHash(content) {
+			fmt.Printf("err: movie '%s' was recorded with another ROM\n", replayFile)
+			return
+		}
+		vm.Quirks, vm.MemoryBytes, vm.Seed, vm.OnError, clkSpeed = movie.Quirks, movie.MemorySize, movie.Seed, movie.OnError, movie.ClockSpeed
+		vm.Input = input.NewReplay(movie.Frames)
+		// Headless replays run the whole movie
+		frames = len(movie.Frames)
+	}
+	if len(recordFile) > 0 {
+		recorder, err := interpreter.CreateMovie(recordFile, &interpreter.Movie{
+			ROM: interpreter.ROMHash(content),
+			Seed: seed,
+			ClockSpeed: clkSpeed,
+			Quirks: quirks,
+			MemorySize: vm.MemoryBytes,
+			OnError: onError,
+		})
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return
+		}
+		vm.Recorder = recorder
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				fmt.Printf("err: %v\n", err)
+			}
+		}()
+	}
+	if vm.Input == nil {
+		// The debugger reads commands from stdin, and headless runs have no
+		// terminal, so neither can use the keyboard
+		source, err := openInput(!debug && !headless)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return
+		}
+		vm.Input = source
+	}
+	if len(traceFile) > 0 {
+		tracer, err := interpreter.CreateTrace(traceFile)
+		if err != nil {
+			fmt.Printf("err: %v\n", err)
+			return
+		}
+		vm.Tracer = tracer
+		defer func() {
+			if err := tracer.Close(); err != nil {
+				fmt.Printf("err: %v\n", err)
+			}
+		}()
+	}
+	if headless {
+		// Fail the process for CI, once the deferred calls above have
+		// closed the trace and movie
+		failed := false
+		defer func() {
+			if failed {
+				os.Exit(1)
+			}
+		}()
+		if err := vm.Init(content, clkSpeed); err != nil {
+			fmt.Printf("err: %v\n", err)
+			failed = true
+			return
+		}
+		if err := loadState(&vm); err != nil {
+			fmt.Printf("err: %v\n", err)
+			failed = true
+			return
+		}
+		if vm.Input != nil {
+			vm.Input.Start()
+			defer vm.Input.Stop()
+		}
+		if _, err := vm.RunFrames(frames); err != nil {
+			fmt.Printf("err: %v\n", err)
+			failed = true
+		}
+		fmt.Print(fb.String())
+		if len(snapshotFile) > 0 {
+			if err := disp.SaveSnapshot(fb, snapshotFile, snapshotScale, palette); err != nil {
+				fmt.Printf("err: %v\n", err)
+				failed = true
+			}
+		}
+		return
+	}
+	statePath := strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".state"
+	// The debugger prints the display on request instead of redrawing it
+	if !debug {
+		vm.Display = &disp.TerminalDisplay{Framebuffer: fb}
+	}
+	vm.Hotkeys = map[rune]func(){
+		// Screenshot the current display
+		'p': func() {
+			path := fmt.Sprintf("snapshot-%s.png", time.Now().Format("20060102-150405"))
+			if err := disp.SaveSnapshot(fb, path, snapshotScale, palette); err != nil {
+				fmt.Printf("err: %v\n", err)
+			}
+		},
+	}
+	// Going back in time would break movies
+	if vm.Recorder == nil && len(replayFile) == 0 {
+		// Save and load the state next to the ROM
+		vm.Hotkeys['k'] = func() {
+			if err := vm.SaveStateFile(statePath); err != nil {
+				fmt.Printf("err: %v\n", err)
+			}
+		}
+		vm.Hotkeys['l'] = func() {
+			if err := vm.LoadStateFile(statePath); err != nil {
+				fmt.Printf("err: %v\n", err)
+			}
+		}
+		// Go back one second
+		vm.Hotkeys['r'] = func() {
+			if vm.Rewind != nil {
+				vm.RewindFrames(60)
+			}
+		}
+		if rewindSeconds > 0 {
+			vm.Rewind = interpreter.NewRewind(rewindSeconds * 60)
+		}
+	}
+	if err := vm.Init(content, clkSpeed); err != nil {
+		fmt.Printf("err: %v\n", err)
+		return
+	}
+	if err := loadState(&vm); err != nil {
+		fmt.Printf("err: %v\n", err)
+		return
+	}
+	if len(gdbAddr) > 0 {
+		if err := gdb.ListenAndServe(&vm, gdbAddr); err != nil {
+			fmt.Printf("err: %v\n", err)
+		}
+		return
+	}
+	if len(dapAddr) > 0 {
+		listingPath := strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".lst"
+		if err := dap.ListenAndServe(&vm, content, listingPath, dapAddr); err != nil {
+			fmt.Printf("err: %v\n", err)
+		}
+		return
 	}
-	vm.Init(content, clkSpeed)
 	if debug {
-		fmt.Println("Running debugger...\nEnter 'n' to step through instructions!")
+		fmt.Println("Running debugger, enter 'help' for a list of commands.")
 	}
 	if err := vm.Run(); err != nil {
-		panic(err)
+		fmt.Printf("err: %v\n", err)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,149 @@
+package octo
+
+import (
+	"fmt"
+	"math"
+)
+
+// Operators of :calc expressions. As in Octo, expressions have no
+// precedence and are evaluated right to left, so "2 * 3 + 1" is 8.
+var unaryOps = map[string]func(float64) float64{
+	"-": func(x float64) float64 { return -x },
+	"~": func(x float64) float64 { return float64(^int(x)) },
+	"!": func(x float64) float64 { return boolValue(x == 0) },
+	"sin": math.Sin,
+	"cos": math.Cos,
+	"tan": math.Tan,
+	"exp": math.Exp,
+	"log": math.Log,
+	"abs": math.Abs,
+	"sqrt": math.Sqrt,
+	"sign": func(x float64) float64 {
+		switch {
+		case x > 0: return 1
+		case x < 0: return -1
+		}
+		return 0
+	},
+	"ceil": math.Ceil,
+	"floor": math.Floor,
+}
+
+var binaryOps = map[string]func(float64, float64) float64{
+	"-": func(x, y float64) float64 { return x - y },
+	"+": func(x, y float64) float64 { return x + y },
+	"*": func(x, y float64) float64 { return x * y },
+	"/": func(x, y float64) float64 { return x / y },
+	"%": func(x, y float64) float64 { return float64(int(x) % int(y)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"&": func(x, y float64) float64 { return float64(int(x) & int(y)) },
+	"|": func(x, y float64) float64 { return float64(int(x) | int(y)) },
+	"^": func(x, y float64) float64 { return float64(int(x) ^ int(y)) },
+	"<<": func(x, y float64) float64 { return float64(int(x) << uint(y)) },
+	">>": func(x, y float64) float64 { return float64(int(x) >> uint(y)) },
+	"<": func(x, y float64) float64 { return boolValue(x < y) },
+	">": func(x, y float64) float64 { return boolValue(x > y) },
+	"<=": func(x, y float64) float64 { return boolValue(x <= y) },
+	">=": func(x, y float64) float64 { return boolValue(x >= y) },
+	"==": func(x, y float64) float64 { return boolValue(x == y) },
+	"!=": func(x, y float64) float64 { return boolValue(x != y) },
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// calc evaluates the tokens of a { ... } expression.
+func (c *compiler) calc(tokens []token) (float64, error) {
+	e := &calcExpr{c: c, tokens: tokens}
+	value, err := e.expr()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos < len(tokens) {
+		return 0, fmt.Errorf("unexpected '%s' in expression", tokens[e.pos].text)
+	}
+	return value, nil
+}
+
+type calcExpr struct {
+	c *compiler
+	tokens []token
+	pos int
+}
+
+func (e *calcExpr) next() (string, error) {
+	if e.pos >= len(e.tokens) {
+		return "", fmt.Errorf("incomplete expression")
+	}
+	e.pos++
+	return e.tokens[e.pos - 1].text, nil
+}
+
+func (e *calcExpr) expr() (float64, error) {
+	left, err := e.term()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos >= len(e.tokens) || e.tokens[e.pos].text == ")" {
+		return left, nil
+	}
+	op, _ := e.next()
+	apply, ok := binaryOps[op]
+	if !ok {
+		return 0, fmt.Errorf("unknown operator '%s'", op)
+	}
+	right, err := e.expr()
+	if err != nil {
+		return 0, err
+	}
+	// % works on integers, which cannot be divided by zero
+	if op == "%" && int(right) == 0 {
+		return 0, fmt.Errorf("modulo by zero in expression")
+	}
+	return apply(left, right), nil
+}
+
+func (e *calcExpr) term() (float64, error) {
+	t, err := e.next()
+	if err != nil {
+		return 0, err
+	}
+	if t == "(" {
+		value, err := e.expr()
+		if err != nil {
+			return 0, err
+		}
+		if close, err := e.next(); err != nil || close != ")" {
+			return 0, fmt.Errorf("expected ')'")
+		}
+		return value, nil
+	}
+	if apply, ok := unaryOps[t]; ok {
+		value, err := e.term()
+		if err != nil {
+			return 0, err
+		}
+		return apply(value), nil
+	}
+	switch t {
+	case "PI": return math.Pi, nil
+	case "E": return math.E, nil
+	case "HERE": return float64(e.c.here), nil
+	}
+	if value, ok := parseNumber(t); ok {
+		return float64(value), nil
+	}
+	if value, ok := e.c.constants[t]; ok {
+		return value, nil
+	}
+	if addr, ok := e.c.labels[t]; ok {
+		return float64(addr), nil
+	}
+	return 0, fmt.Errorf("undefined name '%s' in expression", t)
+}
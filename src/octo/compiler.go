@@ -0,0 +1,953 @@
+// Package octo compiles Octo assembly language into CHIP-8 ROMs.
+package octo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abhinand20/emugo/common"
+)
+
+const (
+	memorySize = 0x10000
+	// Limit on macro expansions, to stop macros that expand themselves
+	maxExpansions = 1 << 16
+)
+
+// Opcode patterns by op, taken from the shared decode table
+var patterns = make(map[common.Op]uint16)
+
+func init() {
+	for _, def := range common.OpDefs {
+		patterns[def.Op] = def.Pattern
+	}
+}
+
+// Kinds of forward reference to patch once a label is defined
+const (
+	fixAddr = iota
+	fixLong
+	fixNibble
+	fixHighByte
+	fixLowByte
+)
+
+type fixup struct {
+	name string
+	addr int
+	kind int
+	line int
+}
+
+type macro struct {
+	args []string
+	body []token
+}
+
+// loopFrame tracks a loop ... again block and the while exits in it.
+type loopFrame struct {
+	start int
+	exits []int
+}
+
+type compiler struct {
+	name string
+	ts *tokenStream
+	rom [memorySize]byte
+	here int
+	end int
+	labels map[string]int
+	constants map[string]float64
+	aliases map[string]byte
+	macros map[string]macro
+	fixups []fixup
+	loops []loopFrame
+	// Jumps of open if ... begin/else blocks
+	branches []int
+	expansions int
+}
+
+// CompileFile compiles the Octo source file at path.
+func CompileFile(path string) (*common.AssembledProgram, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read source '%s': %v", path, err)
+	}
+	return Compile(path, string(source))
+}
+
+// Compile compiles Octo source held in memory, name is used in errors.
+// The program starts at main; unless main is the first label, a jump to
+// it is placed at the start address.
+func Compile(name, source string) (*common.AssembledProgram, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	c := &compiler{
+		name: name,
+		ts: &tokenStream{tokens: tokens},
+		here: common.StartAddr,
+		end: common.StartAddr,
+		labels: make(map[string]int),
+		constants: make(map[string]float64),
+		aliases: make(map[string]byte),
+		macros: make(map[string]macro),
+	}
+	if len(tokens) < 2 || tokens[0].text != ":" || tokens[1].text != "main" {
+		c.fixups = append(c.fixups, fixup{name: "main", addr: c.here, kind: fixAddr})
+		if err := c.emit(patterns[common.OpJP]); err != nil {
+			return nil, err
+		}
+	}
+	for !c.ts.done() {
+		if err := c.statement(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, c.ts.line, err)
+		}
+	}
+	if len(c.loops) > 0 {
+		return nil, fmt.Errorf("%s: 'loop' without 'again'", name)
+	}
+	if len(c.branches) > 0 {
+		return nil, fmt.Errorf("%s: 'begin' without 'end'", name)
+	}
+	for _, f := range c.fixups {
+		if err := c.patch(f); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, f.line, err)
+		}
+	}
+	program := &common.AssembledProgram{
+		Bytes: append([]byte{}, c.rom[common.StartAddr:c.end]...),
+		Symbols: make(map[string]uint16),
+	}
+	for label, addr := range c.labels {
+		program.Symbols[label] = uint16(addr)
+	}
+	for name, value := range c.constants {
+		program.Symbols[name] = uint16(int(value))
+	}
+	return program, nil
+}
+
+func (c *compiler) patch(f fixup) error {
+	addr, ok := c.labels[f.name]
+	if !ok {
+		if f.name == "main" {
+			return fmt.Errorf("program has no 'main' label")
+		}
+		return fmt.Errorf("undefined label '%s'", f.name)
+	}
+	return c.apply(f, addr)
+}
+
+// apply writes the resolved address of a forward reference.
+func (c *compiler) apply(f fixup, addr int) error {
+	switch f.kind {
+	case fixAddr:
+		if addr > 0xFFF {
+			return fmt.Errorf("label '%s' at 0x%X is out of reach, use 'i := long'", f.name, addr)
+		}
+		c.rom[f.addr] |= byte(addr >> 8)
+		c.rom[f.addr + 1] = byte(addr)
+	case fixLong:
+		c.rom[f.addr] = byte(addr >> 8)
+		c.rom[f.addr + 1] = byte(addr)
+	case fixNibble:
+		if addr > 0xFFF {
+			return fmt.Errorf("label '%s' at 0x%X does not fit in 12 bits", f.name, addr)
+		}
+		c.rom[f.addr] |= byte(addr >> 8)
+	case fixHighByte: c.rom[f.addr] = byte(addr >> 8)
+	case fixLowByte: c.rom[f.addr] = byte(addr)
+	}
+	return nil
+}
+
+func (c *compiler) emitByte(b byte) error {
+	if c.here >= memorySize {
+		return fmt.Errorf("program does not fit in memory")
+	}
+	c.rom[c.here] = b
+	c.here++
+	c.end = max(c.end, c.here)
+	return nil
+}
+
+func (c *compiler) emit(opcode uint16) error {
+	if err := c.emitByte(byte(opcode >> 8)); err != nil {
+		return err
+	}
+	return c.emitByte(byte(opcode))
+}
+
+// emitOp emits op with the x and y registers and the low bits set.
+func (c *compiler) emitOp(op common.Op, x, y byte, low uint16) error {
+	return c.emit(patterns[op] | uint16(x) << 8 | uint16(y) << 4 | low)
+}
+
+// emitJump emits a jump whose address is patched later, returning where.
+func (c *compiler) emitJump() (int, error) {
+	addr := c.here
+	return addr, c.emit(patterns[common.OpJP])
+}
+
+// resolveJump points the jump at addr to the current address.
+func (c *compiler) resolveJump(addr int) error {
+	if c.here > 0xFFF {
+		return fmt.Errorf("block ends at 0x%X, out of reach of a jump", c.here)
+	}
+	c.rom[addr] |= byte(c.here >> 8)
+	c.rom[addr + 1] = byte(c.here)
+	return nil
+}
+
+func (c *compiler) define(name string) error {
+	if _, ok := c.labels[name]; ok {
+		return fmt.Errorf("'%s' already defined", name)
+	}
+	if _, ok := c.constants[name]; ok {
+		return fmt.Errorf("'%s' already defined", name)
+	}
+	if _, ok := c.register(name); ok {
+		return fmt.Errorf("'%s' is a register", name)
+	}
+	if _, ok := parseNumber(name); ok {
+		return fmt.Errorf("'%s' is a number", name)
+	}
+	return nil
+}
+
+func (c *compiler) statement() error {
+	t, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	if m, ok := c.macros[t.text]; ok {
+		return c.expand(m)
+	}
+	if x, ok := c.register(t.text); ok {
+		return c.assignment(x)
+	}
+	switch t.text {
+	case ":":
+		name, err := c.ts.next()
+		if err != nil {
+			return err
+		}
+		if err := c.define(name.text); err != nil {
+			return err
+		}
+		c.labels[name.text] = c.here
+		return nil
+	case ":next":
+		// Labels the operand byte of the next instruction
+		name, err := c.ts.next()
+		if err != nil {
+			return err
+		}
+		if err := c.define(name.text); err != nil {
+			return err
+		}
+		c.labels[name.text] = c.here + 1
+		return nil
+	case ":alias":
+		name, err := c.ts.next()
+		if err != nil {
+			return err
+		}
+		// The register may be computed, as in ":alias x { 2 + 1 }"
+		if next, ok := c.ts.peek(); ok && next.text == "{" {
+			tokens, _ := c.ts.block()
+			value, err := c.calc(tokens)
+			if err != nil {
+				return err
+			}
+			if value < 0 || value > 0xF {
+				return fmt.Errorf("register %v out of range", value)
+			}
+			c.aliases[name.text] = byte(value)
+			return nil
+		}
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		c.aliases[name.text] = x
+		return nil
+	case ":const":
+		name, err := c.ts.next()
+		if err != nil {
+			return err
+		}
+		if err := c.define(name.text); err != nil {
+			return err
+		}
+		value, err := c.constant()
+		if err != nil {
+			return err
+		}
+		c.constants[name.text] = value
+		return nil
+	case ":calc":
+		name, err := c.ts.next()
+		if err != nil {
+			return err
+		}
+		return c.defineCalc(name.text)
+	case ":byte":
+		value, err := c.constant()
+		if err != nil {
+			return err
+		}
+		return c.emitByte(byte(int(value)))
+	case ":org":
+		value, err := c.constant()
+		if err != nil {
+			return err
+		}
+		if value < common.StartAddr || value >= memorySize {
+			return fmt.Errorf("org address 0x%X outside of program space", int(value))
+		}
+		c.here = int(value)
+		return nil
+	case ":unpack":
+		return c.unpack()
+	case ":macro":
+		return c.defineMacro()
+	case ":call":
+		return c.addressOp(common.OpCALL)
+	case ":breakpoint":
+		_, err := c.ts.next()
+		return err
+	case ":monitor":
+		if _, err := c.ts.next(); err != nil {
+			return err
+		}
+		_, err := c.ts.next()
+		return err
+	case ";", "return": return c.emitOp(common.OpRET, 0, 0, 0)
+	case "clear": return c.emitOp(common.OpCLS, 0, 0, 0)
+	case "hires": return c.emitOp(common.OpHIGH, 0, 0, 0)
+	case "lores": return c.emitOp(common.OpLOW, 0, 0, 0)
+	case "exit": return c.emitOp(common.OpEXIT, 0, 0, 0)
+	case "scroll-left": return c.emitOp(common.OpSCL, 0, 0, 0)
+	case "scroll-right": return c.emitOp(common.OpSCR, 0, 0, 0)
+	case "audio": return c.emitOp(common.OpAUDIO, 0, 0, 0)
+	case "scroll-down", "scroll-up":
+		n, err := c.number(0, 0xF)
+		if err != nil {
+			return err
+		}
+		if t.text == "scroll-down" {
+			return c.emitOp(common.OpSCD, 0, 0, uint16(n))
+		}
+		return c.emitOp(common.OpSCU, 0, 0, uint16(n))
+	case "plane":
+		n, err := c.number(0, 0xF)
+		if err != nil {
+			return err
+		}
+		return c.emitOp(common.OpPLANE, byte(n), 0, 0)
+	case "bcd": return c.registerOp(common.OpLDB)
+	case "saveflags": return c.registerOp(common.OpSTRRPL)
+	case "loadflags": return c.registerOp(common.OpLDRPL)
+	case "save": return c.loadStore(common.OpSTR, common.OpSAVE)
+	case "load": return c.loadStore(common.OpLDR, common.OpLOAD)
+	case "sprite":
+		x, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		y, err := c.nextRegister()
+		if err != nil {
+			return err
+		}
+		n, err := c.number(0, 0xF)
+		if err != nil {
+			return err
+		}
+		return c.emitOp(common.OpDRW, x, y, uint16(n))
+	case "jump": return c.addressOp(common.OpJP)
+	case "jump0": return c.addressOp(common.OpJPV0)
+	case "native": return c.addressOp(common.OpSYS)
+	case "delay", "buzzer", "pitch":
+		if err := c.ts.expect(":="); err != nil {
+			return err
+		}
+		switch t.text {
+		case "delay": return c.registerOp(common.OpLDDT)
+		case "buzzer": return c.registerOp(common.OpLDST)
+		}
+		return c.registerOp(common.OpPITCH)
+	case "i", "I":
+		return c.indexAssignment()
+	case "if":
+		return c.ifStatement()
+	case "else":
+		if len(c.branches) == 0 {
+			return fmt.Errorf("'else' without 'begin'")
+		}
+		jump, err := c.emitJump()
+		if err != nil {
+			return err
+		}
+		if err := c.resolveJump(c.branches[len(c.branches) - 1]); err != nil {
+			return err
+		}
+		c.branches[len(c.branches) - 1] = jump
+		return nil
+	case "end":
+		if len(c.branches) == 0 {
+			return fmt.Errorf("'end' without 'begin'")
+		}
+		jump := c.branches[len(c.branches) - 1]
+		c.branches = c.branches[:len(c.branches) - 1]
+		return c.resolveJump(jump)
+	case "loop":
+		c.loops = append(c.loops, loopFrame{start: c.here})
+		return nil
+	case "while":
+		if len(c.loops) == 0 {
+			return fmt.Errorf("'while' outside of a loop")
+		}
+		if err := c.condition(true); err != nil {
+			return err
+		}
+		jump, err := c.emitJump()
+		if err != nil {
+			return err
+		}
+		frame := &c.loops[len(c.loops) - 1]
+		frame.exits = append(frame.exits, jump)
+		return nil
+	case "again":
+		if len(c.loops) == 0 {
+			return fmt.Errorf("'again' without 'loop'")
+		}
+		frame := c.loops[len(c.loops) - 1]
+		c.loops = c.loops[:len(c.loops) - 1]
+		if frame.start > 0xFFF {
+			return fmt.Errorf("loop at 0x%X out of reach of a jump", frame.start)
+		}
+		if err := c.emitOp(common.OpJP, 0, 0, uint16(frame.start)); err != nil {
+			return err
+		}
+		for _, jump := range frame.exits {
+			if err := c.resolveJump(jump); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if strings.HasPrefix(t.text, ":") {
+		return fmt.Errorf("unknown directive '%s'", t.text)
+	}
+	// Numbers and constants on their own are data bytes
+	value, isNumber := parseNumber(t.text)
+	if constant, ok := c.constants[t.text]; ok {
+		value, isNumber = int(constant), true
+	}
+	if isNumber {
+		if value < -0x80 || value > 0xFF {
+			return fmt.Errorf("byte %d out of range", value)
+		}
+		return c.emitByte(byte(value))
+	}
+	// Any other name calls a subroutine, possibly defined later
+	return c.reference(common.OpCALL, t.text)
+}
+
+// assignment compiles "vx <op> ..." statements.
+func (c *compiler) assignment(x byte) error {
+	op, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	rhs, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	y, isRegister := c.register(rhs.text)
+	switch op.text {
+	case ":=":
+		switch {
+		case isRegister: return c.emitOp(common.OpLD, x, y, 0)
+		case rhs.text == "key": return c.emitOp(common.OpLDKEY, x, 0, 0)
+		case rhs.text == "delay": return c.emitOp(common.OpLDVxDT, x, 0, 0)
+		case rhs.text == "random":
+			n, err := c.number(-0x80, 0xFF)
+			if err != nil {
+				return err
+			}
+			return c.emitOp(common.OpRND, x, 0, uint16(n) & 0xFF)
+		}
+		n, err := c.byteValue(rhs.text)
+		if err != nil {
+			return err
+		}
+		return c.emitOp(common.OpLDVal, x, 0, n)
+	case "+=", "-=":
+		if isRegister {
+			if op.text == "+=" {
+				return c.emitOp(common.OpADD, x, y, 0)
+			}
+			return c.emitOp(common.OpSUB, x, y, 0)
+		}
+		n, err := c.byteValue(rhs.text)
+		if err != nil {
+			return err
+		}
+		if op.text == "-=" {
+			n = -n & 0xFF
+		}
+		return c.emitOp(common.OpADDVal, x, 0, n)
+	}
+	ops := map[string]common.Op{
+		"=-": common.OpSUBN,
+		"|=": common.OpOR,
+		"&=": common.OpAND,
+		"^=": common.OpXOR,
+		">>=": common.OpSHR,
+		"<<=": common.OpSHL,
+	}
+	regOp, ok := ops[op.text]
+	if !ok {
+		return fmt.Errorf("unknown operator '%s'", op.text)
+	}
+	if !isRegister {
+		return fmt.Errorf("'%s' expects a register, got '%s'", op.text, rhs.text)
+	}
+	return c.emitOp(regOp, x, y, 0)
+}
+
+// indexAssignment compiles "i := ..." and "i += vx".
+func (c *compiler) indexAssignment() error {
+	op, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	switch op.text {
+	case "+=": return c.registerOp(common.OpADDI)
+	case ":=":
+	default:
+		return fmt.Errorf("unknown operator 'i %s'", op.text)
+	}
+	rhs, ok := c.ts.peek()
+	if !ok {
+		return fmt.Errorf("'i :=' expects a value")
+	}
+	switch rhs.text {
+	case "hex", "bighex":
+		c.ts.next()
+		if rhs.text == "hex" {
+			return c.registerOp(common.OpLDF)
+		}
+		return c.registerOp(common.OpLDHF)
+	case "long":
+		c.ts.next()
+		name, err := c.ts.next()
+		if err != nil {
+			return err
+		}
+		if err := c.emitOp(common.OpLDILong, 0, 0, 0); err != nil {
+			return err
+		}
+		if value, ok := c.value(name.text); ok {
+			return c.emit(uint16(value))
+		}
+		c.fixups = append(c.fixups, fixup{name: name.text, addr: c.here, kind: fixLong, line: c.ts.line})
+		return c.emit(0)
+	}
+	return c.addressOp(common.OpLDI)
+}
+
+// ifStatement compiles "if cond then statement" and "if cond begin".
+func (c *compiler) ifStatement() error {
+	// The kind of block follows the condition, which may be 2 or 3 tokens
+	block := ""
+	for idx := c.ts.pos + 2; idx <= c.ts.pos + 3 && idx < len(c.ts.tokens); idx++ {
+		if text := c.ts.tokens[idx].text; text == "then" || text == "begin" {
+			block = text
+			break
+		}
+	}
+	if block == "" {
+		return fmt.Errorf("'if' expects 'then' or 'begin'")
+	}
+	if err := c.condition(block == "begin"); err != nil {
+		return err
+	}
+	if err := c.ts.expect(block); err != nil {
+		return err
+	}
+	if block == "then" {
+		return nil
+	}
+	jump, err := c.emitJump()
+	if err != nil {
+		return err
+	}
+	c.branches = append(c.branches, jump)
+	return nil
+}
+
+var negatedConditions = map[string]string{
+	"==": "!=", "!=": "==",
+	"<": ">=", ">=": "<",
+	">": "<=", "<=": ">",
+	"key": "-key", "-key": "key",
+}
+
+// condition emits code that skips the next instruction unless the
+// condition holds, or unless it fails when negate is set. Comparisons
+// other than equality use vf as scratch.
+func (c *compiler) condition(negate bool) error {
+	x, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	op, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	cmp := op.text
+	if _, ok := negatedConditions[cmp]; !ok {
+		return fmt.Errorf("unknown comparison '%s'", cmp)
+	}
+	if negate {
+		cmp = negatedConditions[cmp]
+	}
+	switch cmp {
+	case "key": return c.emitOp(common.OpSKNP, x, 0, 0)
+	case "-key": return c.emitOp(common.OpSKP, x, 0, 0)
+	}
+	rhs, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	y, isRegister := c.register(rhs.text)
+	var n uint16
+	if !isRegister {
+		if n, err = c.byteValue(rhs.text); err != nil {
+			return err
+		}
+	}
+	const vf = 0xF
+	switch cmp {
+	case "==":
+		if isRegister {
+			return c.emitOp(common.OpSNE, x, y, 0)
+		}
+		return c.emitOp(common.OpSNEVal, x, 0, n)
+	case "!=":
+		if isRegister {
+			return c.emitOp(common.OpSE, x, y, 0)
+		}
+		return c.emitOp(common.OpSEVal, x, 0, n)
+	case "<", ">=":
+		// vf holds the carry of x - rhs, set when x >= rhs
+		if isRegister {
+			err = c.emitOp(common.OpLD, vf, x, 0)
+			if err == nil {
+				err = c.emitOp(common.OpSUB, vf, y, 0)
+			}
+		} else {
+			err = c.emitOp(common.OpLDVal, vf, 0, n)
+			if err == nil {
+				err = c.emitOp(common.OpSUBN, vf, x, 0)
+			}
+		}
+	case ">", "<=":
+		// vf holds the carry of rhs - x, set when x <= rhs
+		if isRegister {
+			err = c.emitOp(common.OpLD, vf, y, 0)
+		} else {
+			err = c.emitOp(common.OpLDVal, vf, 0, n)
+		}
+		if err == nil {
+			err = c.emitOp(common.OpSUB, vf, x, 0)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if cmp == "<" || cmp == ">" {
+		return c.emitOp(common.OpSNEVal, vf, 0, 0)
+	}
+	return c.emitOp(common.OpSNEVal, vf, 0, 1)
+}
+
+// loadStore compiles "save vx" and "load vx", or the "vx - vy" ranges.
+func (c *compiler) loadStore(op, rangeOp common.Op) error {
+	x, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	if next, ok := c.ts.peek(); !ok || next.text != "-" {
+		return c.emitOp(op, x, 0, 0)
+	}
+	c.ts.next()
+	y, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	return c.emitOp(rangeOp, x, y, 0)
+}
+
+// unpack compiles ":unpack n label" into v0 := n << 4 | label >> 8 and
+// v1 := label & 0xFF, or ":unpack long label" into the 16-bit address.
+func (c *compiler) unpack() error {
+	t, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	long := t.text == "long"
+	nibble := 0
+	if !long {
+		value, ok := c.value(t.text)
+		if !ok || value < 0 || value > 0xF {
+			return fmt.Errorf("':unpack' expects 'long' or a nibble, got '%s'", t.text)
+		}
+		nibble = value
+	}
+	name, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	hi := c.here + 1
+	if err := c.emitOp(common.OpLDVal, 0, 0, uint16(nibble << 4)); err != nil {
+		return err
+	}
+	lo := c.here + 1
+	if err := c.emitOp(common.OpLDVal, 1, 0, 0); err != nil {
+		return err
+	}
+	hiKind := fixNibble
+	if long {
+		hiKind = fixHighByte
+	}
+	for _, f := range []fixup{{name: name.text, addr: hi, kind: hiKind}, {name: name.text, addr: lo, kind: fixLowByte}} {
+		f.line = c.ts.line
+		value, ok := c.value(f.name)
+		if !ok {
+			c.fixups = append(c.fixups, f)
+			continue
+		}
+		if err := c.apply(f, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defineMacro reads ":macro name args... { body }".
+func (c *compiler) defineMacro() error {
+	name, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	var m macro
+	for {
+		t, ok := c.ts.peek()
+		if !ok {
+			return fmt.Errorf("macro '%s' has no body", name.text)
+		}
+		if t.text == "{" {
+			break
+		}
+		c.ts.next()
+		m.args = append(m.args, t.text)
+	}
+	if m.body, err = c.ts.block(); err != nil {
+		return err
+	}
+	c.macros[name.text] = m
+	return nil
+}
+
+// expand substitutes the macro's arguments into its body, which is then
+// compiled in place of the invocation.
+func (c *compiler) expand(m macro) error {
+	c.expansions++
+	if c.expansions > maxExpansions {
+		return fmt.Errorf("too many macro expansions, is a macro expanding itself?")
+	}
+	args := make(map[string]string)
+	for _, arg := range m.args {
+		t, err := c.ts.next()
+		if err != nil {
+			return err
+		}
+		args[arg] = t.text
+	}
+	body := make([]token, len(m.body))
+	for idx, t := range m.body {
+		if value, ok := args[t.text]; ok {
+			t.text = value
+		}
+		// Report errors at the invocation
+		t.line = c.ts.line
+		body[idx] = t
+	}
+	c.ts.push(body)
+	return nil
+}
+
+func (c *compiler) defineCalc(name string) error {
+	if err := c.define(name); err != nil {
+		return err
+	}
+	tokens, err := c.ts.block()
+	if err != nil {
+		return err
+	}
+	value, err := c.calc(tokens)
+	if err != nil {
+		return err
+	}
+	c.constants[name] = value
+	return nil
+}
+
+// constant reads a number, a known name or a { ... } expression.
+func (c *compiler) constant() (float64, error) {
+	if t, ok := c.ts.peek(); ok && t.text == "{" {
+		tokens, err := c.ts.block()
+		if err != nil {
+			return 0, err
+		}
+		return c.calc(tokens)
+	}
+	t, err := c.ts.next()
+	if err != nil {
+		return 0, err
+	}
+	if value, ok := c.constants[t.text]; ok {
+		return value, nil
+	}
+	if value, ok := c.value(t.text); ok {
+		return float64(value), nil
+	}
+	return 0, fmt.Errorf("undefined name '%s'", t.text)
+}
+
+// value looks up a number, constant or already defined label.
+func (c *compiler) value(text string) (int, bool) {
+	if value, ok := parseNumber(text); ok {
+		return value, true
+	}
+	if value, ok := c.constants[text]; ok {
+		return int(value), true
+	}
+	if addr, ok := c.labels[text]; ok {
+		return addr, true
+	}
+	return 0, false
+}
+
+func (c *compiler) number(lo, hi int) (int, error) {
+	t, err := c.ts.next()
+	if err != nil {
+		return 0, err
+	}
+	value, ok := c.value(t.text)
+	if !ok {
+		return 0, fmt.Errorf("undefined name '%s'", t.text)
+	}
+	if value < lo || value > hi {
+		return 0, fmt.Errorf("value %d of '%s' out of range [%d, %d]", value, t.text, lo, hi)
+	}
+	return value, nil
+}
+
+func (c *compiler) byteValue(text string) (uint16, error) {
+	value, ok := c.value(text)
+	if !ok {
+		return 0, fmt.Errorf("undefined name '%s'", text)
+	}
+	if value < -0x80 || value > 0xFF {
+		return 0, fmt.Errorf("value %d of '%s' out of range [-128, 255]", value, text)
+	}
+	return uint16(value) & 0xFF, nil
+}
+
+// addressOp emits op with the 12-bit address read next.
+func (c *compiler) addressOp(op common.Op) error {
+	t, err := c.ts.next()
+	if err != nil {
+		return err
+	}
+	return c.reference(op, t.text)
+}
+
+// reference emits op addressing name, patched later if not yet defined.
+func (c *compiler) reference(op common.Op, name string) error {
+	if value, ok := c.value(name); ok {
+		if value < 0 || value > 0xFFF {
+			return fmt.Errorf("address 0x%X of '%s' out of range", value, name)
+		}
+		return c.emitOp(op, 0, 0, uint16(value))
+	}
+	c.fixups = append(c.fixups, fixup{name: name, addr: c.here, kind: fixAddr, line: c.ts.line})
+	return c.emitOp(op, 0, 0, 0)
+}
+
+func (c *compiler) registerOp(op common.Op) error {
+	x, err := c.nextRegister()
+	if err != nil {
+		return err
+	}
+	return c.emitOp(op, x, 0, 0)
+}
+
+func (c *compiler) nextRegister() (byte, error) {
+	t, err := c.ts.next()
+	if err != nil {
+		return 0, err
+	}
+	x, ok := c.register(t.text)
+	if !ok {
+		return 0, fmt.Errorf("expected a register, got '%s'", t.text)
+	}
+	return x, nil
+}
+
+// register parses v0-vF or an alias.
+func (c *compiler) register(text string) (byte, bool) {
+	if x, ok := c.aliases[text]; ok {
+		return x, true
+	}
+	if len(text) != 2 || (text[0] != 'v' && text[0] != 'V') {
+		return 0, false
+	}
+	x, err := strconv.ParseUint(text[1:], 16, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(x), true
+}
+
+// parseNumber reads decimal, 0x hex and 0b binary numbers, optionally
+// negative.
+func parseNumber(s string) (int, bool) {
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	base := 10
+	lower := strings.ToLower(digits)
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		base, digits = 16, digits[2:]
+	case strings.HasPrefix(lower, "0b"):
+		base, digits = 2, digits[2:]
+	}
+	value, err := strconv.ParseInt(digits, base, 32)
+	if err != nil || strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "-") {
+		return 0, false
+	}
+	if negative {
+		value = -value
+	}
+	return int(value), true
+}
@@ -0,0 +1,104 @@
+package octo
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []byte
+	}{
+		{"instructions", ": main clear v1 := 0x2A sprite v0 v1 5 return", []byte{0x00, 0xE0, 0x61, 0x2A, 0xD0, 0x15, 0x00, 0xEE}},
+		{"comments", ": main # clear the screen\nclear", []byte{0x00, 0xE0}},
+		{"const", ": main :const SPEED 5 v0 := SPEED", []byte{0x60, 0x05}},
+		{"calc right to left", ": main :const N 5 :calc M { N * 2 + 1 } v0 := M", []byte{0x60, 0x0F}},
+		{"calc parentheses", ": main :calc M { ( 2 * 3 ) + 1 } v0 := M", []byte{0x60, 0x07}},
+		{"calc modulo", ": main :calc M { 7 % 4 } v0 := M", []byte{0x60, 0x03}},
+		{"backward label", ": main jump main", []byte{0x12, 0x00}},
+		{"forward call", ": main sub : sub ;", []byte{0x22, 0x02, 0x00, 0xEE}},
+		{"jump to main", ": sub ; : main sub", []byte{0x12, 0x04, 0x00, 0xEE, 0x22, 0x02}},
+		{"macro", ": main :macro twice X { X X } twice clear", []byte{0x00, 0xE0, 0x00, 0xE0}},
+		{"macro arguments", ": main :macro set R N { R := N } set v3 7", []byte{0x63, 0x07}},
+		{"if then", ": main if v1 == 3 then v2 := 1", []byte{0x41, 0x03, 0x62, 0x01}},
+		{"if register then", ": main if v1 != v2 then clear", []byte{0x51, 0x20, 0x00, 0xE0}},
+		{"if less than", ": main if v1 < 5 then clear", []byte{0x6F, 0x05, 0x8F, 0x17, 0x4F, 0x00, 0x00, 0xE0}},
+		{"if begin else end", ": main if v1 == 3 begin v2 := 1 else v2 := 2 end", []byte{0x31, 0x03, 0x12, 0x08, 0x62, 0x01, 0x12, 0x0A, 0x62, 0x02}},
+		{"loop while again", ": main loop while v0 != 5 v0 += 1 again", []byte{0x40, 0x05, 0x12, 0x08, 0x70, 0x01, 0x12, 0x00}},
+		{"key", ": main if v3 key then clear", []byte{0xE3, 0xA1, 0x00, 0xE0}},
+		{"not key", ": main if v3 -key then clear", []byte{0xE3, 0x9E, 0x00, 0xE0}},
+		{"wait for key", ": main v4 := key", []byte{0xF4, 0x0A}},
+		{"data bytes", ": main 1 2 0xFF", []byte{0x01, 0x02, 0xFF}},
+		{"long index", ": main i := long data : data 7", []byte{0xF0, 0x00, 0x02, 0x04, 0x07}},
+		{"unpack", ": main :unpack 0xA data : data", []byte{0x60, 0xA2, 0x61, 0x04}},
+		{"alias", ": main :alias x v5 x += 1", []byte{0x75, 0x01}},
+		{"save range", ": main save v2 - v4", []byte{0x52, 0x42}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			program, err := Compile("test.8o", tc.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(program.Bytes, tc.want) {
+				t.Errorf("got % X, want % X", program.Bytes, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileSymbols(t *testing.T) {
+	program, err := Compile("test.8o", ": main :const N 3 clear : end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint16{"main": 0x200, "end": 0x202, "N": 3}
+	for name, value := range want {
+		if program.Symbols[name] != value {
+			t.Errorf("symbol %s = 0x%X, want 0x%X", name, program.Symbols[name], value)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"modulo by zero", ": main\n:calc foo { 5 % 0 }", "test.8o:2: modulo by zero in expression"},
+		{"modulo by a fraction", ": main :calc foo { 5 % ( 1 / 2 ) }", "modulo by zero in expression"},
+		{"undefined name in calc", ": main :calc foo { bar + 1 }", "undefined name 'bar' in expression"},
+		{"unbalanced calc", ": main :calc foo { ( 1 + 2 }", "expected ')'"},
+		{"undefined label", ": main\nclear\njump nowhere", "test.8o:3: undefined label 'nowhere'"},
+		{"no main", ": sub ;", "program has no 'main' label"},
+		{"duplicate label", ": main : main", "'main' already defined"},
+		{"label named after a register", ": main : v3", "'v3' is a register"},
+		{"byte out of range", ": main\n\nv0 := 300", "test.8o:3: value 300 of '300' out of range [-128, 255]"},
+		{"unknown directive", ": main :foo", "unknown directive ':foo'"},
+		{"unknown operator", ": main v0 %= v1", "unknown operator '%='"},
+		{"if without then", ": main if v0 == 1 clear", "'if' expects 'then' or 'begin'"},
+		{"begin without end", ": main if v0 == 1 begin clear", "'begin' without 'end'"},
+		{"else without begin", ": main else", "'else' without 'begin'"},
+		{"end without begin", ": main end", "'end' without 'begin'"},
+		{"loop without again", ": main loop clear", "'loop' without 'again'"},
+		{"again without loop", ": main again", "'again' without 'loop'"},
+		{"while outside loop", ": main while v0 == 1", "'while' outside of a loop"},
+		{"unknown comparison", ": main if v0 ~ 1 then clear", "unknown comparison '~'"},
+		{"recursive macro", ": main :macro m { m } m", "too many macro expansions"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile("test.8o", tc.source)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tc.want)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %q does not contain %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package octo
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// token is a whitespace separated word of the source with its line.
+type token struct {
+	text string
+	line int
+}
+
+func (t token) String() string {
+	return t.text
+}
+
+// tokenize splits source into tokens, dropping '#' comments. Quoted
+// strings are kept as a single token.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for idx, line := range strings.Split(source, "\n") {
+		lineNo := idx + 1
+		rest := line
+		for {
+			rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+			if len(rest) == 0 || rest[0] == '#' {
+				break
+			}
+			if rest[0] == '"' {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: unterminated string", lineNo)
+				}
+				tokens = append(tokens, token{text: rest[:end + 2], line: lineNo})
+				rest = rest[end + 2:]
+				continue
+			}
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			tokens = append(tokens, token{text: rest[:end], line: lineNo})
+			rest = rest[end:]
+		}
+	}
+	return tokens, nil
+}
+
+// tokenStream hands out tokens, with macro expansions pushed at the front.
+type tokenStream struct {
+	tokens []token
+	pos int
+	// Line of the last token read, for error messages
+	line int
+}
+
+func (s *tokenStream) done() bool {
+	return s.pos >= len(s.tokens)
+}
+
+func (s *tokenStream) peek() (token, bool) {
+	if s.done() {
+		return token{}, false
+	}
+	return s.tokens[s.pos], true
+}
+
+func (s *tokenStream) next() (token, error) {
+	if s.done() {
+		return token{}, fmt.Errorf("unexpected end of file")
+	}
+	t := s.tokens[s.pos]
+	s.pos++
+	s.line = t.line
+	return t, nil
+}
+
+// expect reads the next token and checks it is text.
+func (s *tokenStream) expect(text string) error {
+	t, err := s.next()
+	if err != nil {
+		return fmt.Errorf("expected '%s': %v", text, err)
+	}
+	if t.text != text {
+		return fmt.Errorf("expected '%s', got '%s'", text, t.text)
+	}
+	return nil
+}
+
+// push inserts tokens to be read next.
+func (s *tokenStream) push(tokens []token) {
+	rest := append(append([]token{}, tokens...), s.tokens[s.pos:]...)
+	s.tokens = rest
+	s.pos = 0
+}
+
+// block reads the tokens of a { ... } group, handling nesting.
+func (s *tokenStream) block() ([]token, error) {
+	if err := s.expect("{"); err != nil {
+		return nil, err
+	}
+	var body []token
+	depth := 1
+	for {
+		t, err := s.next()
+		if err != nil {
+			return nil, fmt.Errorf("unterminated block: %v", err)
+		}
+		switch t.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return body, nil
+			}
+		}
+		body = append(body, t)
+	}
+}